@@ -0,0 +1,62 @@
+// Package animation encodes rendered frames of an ANSImation as animated GIF,
+// YUV4MPEG2 video or a sequence of PNG images.
+package animation
+
+import (
+	"image"
+	"time"
+)
+
+// DefaultHold is the display time of the last frame
+const DefaultHold = 3 * time.Second
+
+// Encoder writes the frames of an animation.
+type Encoder interface {
+	// Encode adds a frame that is displayed from time t
+	Encode(m image.Image, t time.Duration) error
+
+	// Close writes the last frame
+	Close() error
+}
+
+// rate repeats frames to play an animation at a constant frame rate
+type rate struct {
+	fps     int
+	hold    *time.Duration
+	written int
+	pending image.Image
+	write   func(m image.Image, n int) error // Write m n times
+}
+
+// frame returns the frame number at time t
+func (r *rate) frame(t time.Duration) int {
+	return int((t*time.Duration(r.fps) + time.Second/2) / time.Second)
+}
+
+func (r *rate) encode(m image.Image, t time.Duration) error {
+	if r.pending != nil {
+		// Frames that are replaced before they are due are dropped
+		if n := r.frame(t) - r.written; n > 0 {
+			if err := r.write(r.pending, n); err != nil {
+				return err
+			}
+			r.written += n
+		}
+	}
+	r.pending = m
+	return nil
+}
+
+func (r *rate) close() error {
+	if r.pending == nil {
+		return nil
+	}
+	n := r.frame(*r.hold)
+	if n < 1 {
+		n = 1
+	}
+	m := r.pending
+	r.pending = nil
+	r.written += n
+	return r.write(m, n)
+}
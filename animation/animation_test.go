@@ -0,0 +1,78 @@
+package animation
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+)
+
+// frame returns a 4x4 black image with a white pixel at x
+func frame(x int) image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range m.Pix {
+		if i%4 == 3 {
+			m.Pix[i] = 0xff
+		}
+	}
+	m.Set(x, 1, color.White)
+	return m
+}
+
+func TestGIF(t *testing.T) {
+	var b bytes.Buffer
+	e := NewGIF(&b)
+	e.Hold = time.Second
+	for _, f := range []struct {
+		x int
+		t time.Duration
+	}{
+		{0, 0},
+		{1, 5 * time.Millisecond}, // Replaced before it is displayed
+		{2, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond}, // Unchanged
+		{3, 500 * time.Millisecond},
+	} {
+		if err := e.Encode(frame(f.x), f.t); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{10, 40, 100}; len(g.Delay) != len(want) || g.Delay[0] != want[0] || g.Delay[1] != want[1] || g.Delay[2] != want[2] {
+		t.Errorf("expected delays %v, got %v", want, g.Delay)
+	}
+	if r := g.Image[2].Bounds(); r != image.Rect(2, 1, 4, 2) {
+		t.Errorf("expected the changed area of the frame, got %v", r)
+	}
+}
+
+func TestY4M(t *testing.T) {
+	var b bytes.Buffer
+	e := NewY4M(&b, 10)
+	e.Hold = 300 * time.Millisecond
+	e.Encode(frame(0), 0)
+	e.Encode(frame(1), 200*time.Millisecond)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header := "YUV4MPEG2 W4 H4 F10:1 Ip A1:1 C444\n"
+	if !bytes.HasPrefix(b.Bytes(), []byte(header)) {
+		t.Fatalf("expected header %q, got %q", header, b.Bytes()[:len(header)])
+	}
+	if n := bytes.Count(b.Bytes(), []byte("FRAME\n")); n != 5 {
+		t.Errorf("expected 5 frames, got %d", n)
+	}
+	if size := len(header) + 5*(6+3*16); b.Len() != size {
+		t.Errorf("expected %d bytes, got %d", size, b.Len())
+	}
+}
@@ -0,0 +1,127 @@
+package animation
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// GIF encodes an animated GIF. Every frame only holds the area that changed,
+// with its own palette.
+type GIF struct {
+	// Hold is the display time of the last frame
+	Hold time.Duration
+
+	w       io.Writer
+	g       gif.GIF
+	prev    *image.RGBA // Last encoded frame
+	pending *image.RGBA
+	at      int // Time of the pending frame, in 1/100s
+}
+
+// NewGIF returns a GIF encoder that writes to w.
+func NewGIF(w io.Writer) *GIF {
+	return &GIF{
+		Hold: DefaultHold,
+		w:    w,
+	}
+}
+
+// Encode adds a frame that is displayed from time t.
+func (e *GIF) Encode(m image.Image, t time.Duration) error {
+	at := int(t / (10 * time.Millisecond))
+	if e.pending != nil && at > e.at {
+		e.add(e.pending, at-e.at)
+	}
+	// A frame that is replaced within 1/100s is never displayed
+	e.pending = toRGBA(m)
+	e.at = at
+	return nil
+}
+
+// Close writes the animation.
+func (e *GIF) Close() error {
+	if e.pending != nil {
+		e.add(e.pending, int(e.Hold/(10*time.Millisecond)))
+		e.pending = nil
+	}
+	if len(e.g.Image) == 0 {
+		return nil
+	}
+	return gif.EncodeAll(e.w, &e.g)
+}
+
+// add a frame with a delay in 1/100s
+func (e *GIF) add(m *image.RGBA, delay int) {
+	r := m.Bounds()
+	if e.prev != nil {
+		if r = changed(e.prev, m); r.Empty() {
+			// Unchanged, display the previous frame longer
+			e.g.Delay[len(e.g.Delay)-1] += delay
+			return
+		}
+	} else {
+		e.g.Config = image.Config{Width: r.Dx(), Height: r.Dy()}
+	}
+	e.g.Image = append(e.g.Image, paletted(m, r))
+	e.g.Delay = append(e.g.Delay, delay)
+	e.g.Disposal = append(e.g.Disposal, gif.DisposalNone)
+	e.prev = m
+}
+
+// changed returns the area that differs between images of the same size
+func changed(a, b *image.RGBA) (r image.Rectangle) {
+	bounds := b.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return
+}
+
+// paletted converts an area of m to a paletted image, with the exact colors
+// if there are no more than 256. Otherwise the colors are approximated.
+func paletted(m *image.RGBA, r image.Rectangle) *image.Paletted {
+	var (
+		p    color.Palette
+		seen = make(map[color.RGBA]bool)
+	)
+scan:
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := m.RGBAAt(x, y)
+			if seen[c] {
+				continue
+			}
+			if len(p) == 256 {
+				p = nil
+				break scan
+			}
+			seen[c] = true
+			p = append(p, c)
+		}
+	}
+
+	if p == nil {
+		i := image.NewPaletted(r, palette.Plan9)
+		draw.FloydSteinberg.Draw(i, r, m, r.Min)
+		return i
+	}
+	i := image.NewPaletted(r, p)
+	draw.Draw(i, r, m, r.Min, draw.Src)
+	return i
+}
+
+// toRGBA returns a copy of m as RGBA image
+func toRGBA(m image.Image) *image.RGBA {
+	i := image.NewRGBA(m.Bounds())
+	draw.Draw(i, i.Bounds(), m, m.Bounds().Min, draw.Src)
+	return i
+}
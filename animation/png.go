@@ -0,0 +1,62 @@
+package animation
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+// PNG encodes a sequence of PNG images at a constant frame rate, a frame
+// that is displayed longer is repeated.
+type PNG struct {
+	// Hold is the display time of the last frame
+	Hold time.Duration
+
+	create func(index int) (io.WriteCloser, error)
+	rate   rate
+}
+
+// NewPNG returns a PNG sequence encoder with fps frames per second, create
+// returns the writer for frame index.
+func NewPNG(fps int, create func(index int) (io.WriteCloser, error)) *PNG {
+	e := &PNG{
+		Hold:   DefaultHold,
+		create: create,
+	}
+	e.rate = rate{fps: fps, hold: &e.Hold, write: e.write}
+	return e
+}
+
+// Encode adds a frame that is displayed from time t.
+func (e *PNG) Encode(m image.Image, t time.Duration) error {
+	return e.rate.encode(m, t)
+}
+
+// Close writes the last frame.
+func (e *PNG) Close() error {
+	return e.rate.close()
+}
+
+func (e *PNG) write(m image.Image, n int) error {
+	for i := 0; i < n; i++ {
+		w, err := e.create(e.rate.written + i)
+		if err != nil {
+			return err
+		}
+		if err = png.Encode(w, m); err != nil {
+			w.Close()
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	_ Encoder = (*GIF)(nil)
+	_ Encoder = (*PNG)(nil)
+	_ Encoder = (*Y4M)(nil)
+)
@@ -0,0 +1,84 @@
+package animation
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+// Y4M encodes a YUV4MPEG2 video stream at a constant frame rate, without
+// chroma subsampling. Frames are cropped to the size of the first frame.
+type Y4M struct {
+	// Hold is the display time of the last frame
+	Hold time.Duration
+
+	w      *bufio.Writer
+	rate   rate
+	header bool
+	size   image.Rectangle
+}
+
+// NewY4M returns a Y4M encoder that writes fps frames per second to w.
+func NewY4M(w io.Writer, fps int) *Y4M {
+	e := &Y4M{
+		Hold: DefaultHold,
+		w:    bufio.NewWriter(w),
+	}
+	e.rate = rate{fps: fps, hold: &e.Hold, write: e.write}
+	return e
+}
+
+// Encode adds a frame that is displayed from time t.
+func (e *Y4M) Encode(m image.Image, t time.Duration) error {
+	return e.rate.encode(m, t)
+}
+
+// Close writes the last frame and flushes the stream.
+func (e *Y4M) Close() error {
+	if err := e.rate.close(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *Y4M) write(m image.Image, n int) (err error) {
+	if !e.header {
+		e.size = m.Bounds()
+		if _, err = fmt.Fprintf(e.w, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444\n",
+			e.size.Dx(), e.size.Dy(), e.rate.fps); err != nil {
+			return
+		}
+		e.header = true
+	}
+
+	// Y, Cb and Cr planes
+	var (
+		size   = e.size.Dx() * e.size.Dy()
+		planes = make([]byte, 3*size)
+		bounds = m.Bounds()
+		i      int
+	)
+	for y := 0; y < e.size.Dy(); y++ {
+		for x := 0; x < e.size.Dx(); x++ {
+			var c color.RGBA
+			if p := image.Pt(x, y).Add(bounds.Min); p.In(bounds) {
+				c = color.RGBAModel.Convert(m.At(p.X, p.Y)).(color.RGBA)
+			}
+			planes[i], planes[size+i], planes[2*size+i] = color.RGBToYCbCr(c.R, c.G, c.B)
+			i++
+		}
+	}
+
+	for ; n > 0; n-- {
+		if _, err = io.WriteString(e.w, "FRAME\n"); err != nil {
+			return
+		}
+		if _, err = e.w.Write(planes); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package buffer
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+// Resampling selects how an image is scaled to correct its aspect ratio.
+type Resampling int
+
+// Resampling modes
+const (
+	Nearest Resampling = iota // repeat the nearest row, keeps the palette
+	Smooth                    // blend the nearest two rows
+)
+
+// AspectRatio returns the vertical scale that displays characters dx pixels
+// wide as they were on a 4:3 VGA screen of 80 columns and 400 lines, or 1 if
+// the SAUCE flags don't ask for legacy aspect ratio correction.
+func (b *Buffer) AspectRatio(dx int) float64 {
+	if b.Flags.AspectRatio != sauce.AspectRatioStretch {
+		return 1
+	}
+	return float64(80*dx) * 3 / 4 / 400
+}
+
+// stretch scales image m vertically by s, a nearest neighbour scaled image
+// keeps its color model.
+func stretch(m image.Image, s float64, mode Resampling) image.Image {
+	var (
+		bounds = m.Bounds()
+		h      = int(math.Round(float64(bounds.Dy()) * s))
+		r      = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+h)
+	)
+	if h == 0 {
+		return m
+	}
+
+	if mode == Nearest {
+		switch m := m.(type) {
+		case *image.Paletted:
+			i := image.NewPaletted(r, m.Palette)
+			copyRows(i.Pix, i.Stride, m.Pix, m.Stride, s)
+			return i
+		case *image.RGBA:
+			i := image.NewRGBA(r)
+			copyRows(i.Pix, i.Stride, m.Pix, m.Stride, s)
+			return i
+		}
+	}
+
+	i := image.NewRGBA(r)
+	for y := 0; y < h; y++ {
+		// Position of the row center in the source
+		sy := (float64(y)+.5)/s - .5
+		y0 := int(math.Floor(sy))
+		t := sy - float64(y0)
+		if mode == Nearest {
+			y0, t = int((float64(y)+.5)/s), 0
+		}
+		y1 := clamp(y0+1, 0, bounds.Dy()-1)
+		y0 = clamp(y0, 0, bounds.Dy()-1)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := color.RGBAModel.Convert(m.At(x, bounds.Min.Y+y0)).(color.RGBA)
+			b := color.RGBAModel.Convert(m.At(x, bounds.Min.Y+y1)).(color.RGBA)
+			i.SetRGBA(x, r.Min.Y+y, color.RGBA{
+				R: blend(a.R, b.R, t),
+				G: blend(a.G, b.G, t),
+				B: blend(a.B, b.B, t),
+				A: blend(a.A, b.A, t),
+			})
+		}
+	}
+	return i
+}
+
+// copyRows fills the rows of dst with the nearest rows of src, scaled by s
+func copyRows(dst []byte, dstStride int, src []byte, srcStride int, s float64) {
+	var (
+		h    = len(dst) / dstStride
+		rows = len(src) / srcStride
+	)
+	for y := 0; y < h; y++ {
+		sy := clamp(int((float64(y)+.5)/s), 0, rows-1)
+		copy(dst[y*dstStride:(y+1)*dstStride], src[sy*srcStride:(sy+1)*srcStride])
+	}
+}
+
+// blend returns a mixed with b by t
+func blend(a, b uint8, t float64) uint8 {
+	return uint8(math.Round(float64(a)*(1-t) + float64(b)*t))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
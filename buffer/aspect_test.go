@@ -0,0 +1,51 @@
+package buffer
+
+import (
+	"image"
+	"testing"
+
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+func TestAspectRatio(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	tests := []struct {
+		Name          string
+		AspectRatio   uint8
+		LetterSpacing uint8
+		Resampling    Resampling
+		Height        int
+		Paletted      bool
+	}{
+		{"square", 0, 0, Nearest, 16, true},
+		{"nearest", sauce.AspectRatioStretch, 0, Nearest, 19, true},
+		{"smooth", sauce.AspectRatioStretch, 0, Smooth, 19, false},
+		{"9 pixel", sauce.AspectRatioStretch, sauce.LetterSpacing9Pixel, Nearest, 22, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			b := New(80, 1)
+			b.Flags.AspectRatio = test.AspectRatio
+			b.Flags.LetterSpacing = test.LetterSpacing
+			b.Resampling = test.Resampling
+			b.Cursor.Background = 4
+			write(b, "A")
+			m, err := b.Image(palette.CGA, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h := m.Bounds().Dy(); h != test.Height {
+				t.Errorf("expected height %d, got %d", test.Height, h)
+			}
+			if _, ok := m.(*image.Paletted); ok != test.Paletted {
+				t.Errorf("expected paletted %t, got %T", test.Paletted, m)
+			}
+			if r, g, b, _ := m.At(0, m.Bounds().Dy()-1).RGBA(); r != 0 || g != 0 || b>>8 != 0xaa {
+				t.Errorf("expected a blue background, got %#x %#x %#x", r, g, b)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package attribute
 
+// None is the absence of attributes
+const None = 0
+
 const (
-	None                    = 0 << iota // No attributes
-	Bold                                // bold or increased intensity
+	Bold                    = 1 << iota // bold or increased intensity
 	Faint                               // faint, decreased intensity or second colour
 	Italics                             // italicized
 	Underline                           // underlined
@@ -20,4 +22,5 @@ const (
 	IdeogramOverline                    // ideogram overline or left side line
 	IdeogramDoubleOverline              // ideogram double overline or double line on the left side
 	IdeogramStressMarking               // ideogram stress marking
+	CurlyUnderline                      // curly underlined (ISO 8613-6)
 )
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/draw"
+	"time"
 
 	"git.maze.io/maze/go-piece/buffer/attribute"
 	"git.maze.io/maze/go-piece/font"
@@ -15,9 +15,14 @@ import (
 )
 
 var (
-	errOutOfBounds = errors.New("Out of bounds")
+	errOutOfBounds  = errors.New("Out of bounds")
+	errEmptyPalette = errors.New("buffer: empty palette")
 )
 
+// BlinkInterval is the time blinking text is shown and hidden, VGA toggles
+// blink every 16 frames at 70 Hz.
+const BlinkInterval = 16 * time.Second / 70
+
 // Buffer holds the information of what would be displayed on a VGA text mode screen.
 type Buffer struct {
 	Width, Height       int
@@ -25,6 +30,17 @@ type Buffer struct {
 	Tiles               []*Tile
 	Flags               sauce.TFlags
 	maxWidth, maxHeight int
+
+	// BoldFont renders bold text with a heavier glyph, in stead of using the
+	// bright variant of the foreground color.
+	BoldFont bool
+
+	// Resampling corrects the aspect ratio of pieces with non-square pixels,
+	// see AspectRatio.
+	Resampling Resampling
+
+	// Limits for growing the buffer
+	Limits Limits
 }
 
 // New creates a new buffer of w x h Tiles. The maximum buffer width is set to
@@ -63,15 +79,135 @@ func (b *Buffer) ClearFrom(o int) {
 
 // ClearTo clears all Tiles up until offset o
 func (b *Buffer) ClearTo(o int) {
-	for o = math.MinInt(o, len(b.Tiles)); o >= 0; o-- {
+	for o = math.MinInt(o, len(b.Tiles)-1); o >= 0; o-- {
 		b.Tiles[o] = nil
 	}
 }
 
-// Insert inserts n Tiles at offset o.
-func (b *Buffer) Insert(o, n int) {
+// Insert inserts n Tiles at offset o, an error is returned if the buffer
+// would exceed its limits.
+func (b *Buffer) Insert(o, n int) error {
+	if err := b.Limits.Check(b.Width, b.rows(len(b.Tiles)+n)); err != nil {
+		return err
+	}
 	p := make([]*Tile, n)
 	b.Tiles = append(b.Tiles[:o], append(p, b.Tiles[o:]...)...)
+	if used := b.maxHeight * b.Width; o < used {
+		// The used Tiles moved down
+		b.maxHeight = b.rows(used + n)
+	}
+	return nil
+}
+
+// DeleteLines removes n lines starting at row y, the lines below move up and
+// blank lines are added at the end of the buffer.
+func (b *Buffer) DeleteLines(y, n int) {
+	o := y * b.Width
+	if o >= len(b.Tiles) || n <= 0 {
+		return
+	}
+	e := math.MinInt(o+n*b.Width, len(b.Tiles))
+	b.Tiles = append(append(b.Tiles[:o], b.Tiles[e:]...), make([]*Tile, e-o)...)
+}
+
+// ScrollUp moves the Tiles inside rectangle r up by n rows, blank rows are
+// added at the bottom of r. The rows that scrolled out of r are returned.
+func (b *Buffer) ScrollUp(r image.Rectangle, n int) (out []*Tile) {
+	r = r.Intersect(image.Rect(0, 0, b.Width, r.Max.Y))
+	if r.Empty() || n <= 0 {
+		return
+	}
+	n = math.MinInt(n, r.Dy())
+	b.Expand(r.Max.Y*b.Width - 1)
+	for y := r.Min.Y; y < r.Min.Y+n; y++ {
+		out = append(out, b.Tiles[y*b.Width+r.Min.X:y*b.Width+r.Max.X]...)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := b.Tiles[y*b.Width+r.Min.X : y*b.Width+r.Max.X]
+		if y+n < r.Max.Y {
+			copy(d, b.Tiles[(y+n)*b.Width+r.Min.X:(y+n)*b.Width+r.Max.X])
+		} else {
+			clearTiles(d)
+		}
+	}
+	return
+}
+
+// ScrollDown moves the Tiles inside rectangle r down by n rows, blank rows
+// are added at the top of r.
+func (b *Buffer) ScrollDown(r image.Rectangle, n int) {
+	r = r.Intersect(image.Rect(0, 0, b.Width, r.Max.Y))
+	if r.Empty() || n <= 0 {
+		return
+	}
+	n = math.MinInt(n, r.Dy())
+	b.Expand(r.Max.Y*b.Width - 1)
+	for y := r.Max.Y - 1; y >= r.Min.Y; y-- {
+		d := b.Tiles[y*b.Width+r.Min.X : y*b.Width+r.Max.X]
+		if y-n >= r.Min.Y {
+			copy(d, b.Tiles[(y-n)*b.Width+r.Min.X:(y-n)*b.Width+r.Max.X])
+		} else {
+			clearTiles(d)
+		}
+	}
+}
+
+// InsertChars inserts n blank Tiles at x, y. Tiles shifted past the end of
+// the line are lost.
+func (b *Buffer) InsertChars(x, y, n int) {
+	r := b.row(y)
+	if r == nil || x >= b.Width || n <= 0 {
+		return
+	}
+	n = math.MinInt(n, b.Width-x)
+	copy(r[x+n:], r[x:b.Width-n])
+	clearTiles(r[x : x+n])
+
+	// Shifted Tiles may extend the used buffer size
+	for w := b.Width; w > b.maxWidth; w-- {
+		if r[w-1] != nil {
+			b.maxWidth = w
+			break
+		}
+	}
+}
+
+// DeleteChars removes n Tiles at x, y. The remainder of the line moves left
+// and blank Tiles are added at the end of the line.
+func (b *Buffer) DeleteChars(x, y, n int) {
+	r := b.row(y)
+	if r == nil || x >= b.Width || n <= 0 {
+		return
+	}
+	n = math.MinInt(n, b.Width-x)
+	copy(r[x:], r[x+n:])
+	clearTiles(r[b.Width-n:])
+}
+
+// EraseChars clears n Tiles from x, y up until the end of the line.
+func (b *Buffer) EraseChars(x, y, n int) {
+	r := b.row(y)
+	if r == nil || x >= b.Width || n <= 0 {
+		return
+	}
+	clearTiles(r[x:math.MinInt(x+n, b.Width)])
+}
+
+// row returns the Tiles of row y, or nil if the row is past the end of the
+// buffer.
+func (b *Buffer) row(y int) []*Tile {
+	o := y * b.Width
+	if y < 0 || o >= len(b.Tiles) {
+		return nil
+	}
+	b.Expand(o + b.Width - 1)
+	return b.Tiles[o : o+b.Width]
+}
+
+func clearTiles(t []*Tile) {
+	for i := range t {
+		t[i] = nil
+	}
 }
 
 // Expand buffer to fit offset o.
@@ -107,7 +243,11 @@ func (b *Buffer) FromMemory(m []byte) (err error) {
 			t.Attributes = 0
 			t.Char = m[mo]
 			t.Color = int(m[mo+1] & 0x0f)
-			t.Background = int((m[mo+1] & 0xf0) >> 4)
+			t.Background = int((m[mo+1] & 0x70) >> 4)
+			if m[mo+1]&0x80 > 0 {
+				// Blink, or a bright background with iCE colors
+				t.Attributes = attribute.Blink
+			}
 		}
 	}
 
@@ -118,6 +258,14 @@ func (b *Buffer) FromMemory(m []byte) (err error) {
 	return
 }
 
+// rows returns the number of rows needed for n tiles
+func (b *Buffer) rows(n int) int {
+	if b.Width <= 0 {
+		return 0
+	}
+	return (n + b.Width - 1) / b.Width
+}
+
 // Len returns the number of possible Tiles (total offset)
 func (b *Buffer) Len() int {
 	return b.Width * b.Height
@@ -177,90 +325,243 @@ func (b *Buffer) Tile(o int) *Tile {
 	return b.Tiles[o]
 }
 
+// framed checks if the tile at x, y has the frame attribute, without
+// allocating tiles.
+func (b *Buffer) framed(x, y int) bool {
+	if x < 0 || x >= b.Width || y < 0 {
+		return false
+	}
+	o := (y * b.Width) + x
+	return o < len(b.Tiles) && b.Tiles[o] != nil && b.Tiles[o].Attributes&attribute.Frame > 0
+}
+
 // TileAt retrieves tile at coorindates x, y.
 func (b *Buffer) TileAt(x, y int) *Tile {
 	return b.Tile((y * b.Width) + x)
 }
 
 // PutChar writes a character to the buffer at the current cursor location and
-// advances the cursor position.
+// advances the cursor position. Nothing is written if the buffer would exceed
+// its limits.
 func (b *Buffer) PutChar(c byte) error {
-	b.Cursor.Char = c
 	o := b.Cursor.Offset(b.Width)
+	if err := b.Limits.Check(math.MaxInt(b.Width, b.Cursor.X+1), b.rows(o+1)); err != nil {
+		return err
+	}
+	b.Cursor.Char = c
 	t := b.Expand(o).Tile(o)
 	t.Update(&b.Cursor.Tile)
+	// The used size includes the tile that was written, also when the
+	// cursor wraps to the next line
+	b.maxWidth = math.MaxInt(b.maxWidth, b.Cursor.X+1)
+	b.maxHeight = math.MaxInt(b.maxHeight, b.Cursor.Y+1)
 	b.Cursor.X++
 	b.Cursor.NormalizeAndWrap(b.Width)
-	b.maxWidth = math.MaxInt(b.maxWidth, b.Cursor.X)
-	b.maxHeight = math.MaxInt(b.maxHeight, b.Cursor.Y)
 	return nil
 }
 
+// TileColors returns the palette indices used to display a tile, taking the
+// bold, blink and negative attributes into account.
+func (b *Buffer) TileColors(t *Tile) (fg, bg int) {
+	fg = t.Color
+	bg = t.Background
+	if !b.BoldFont && t.Attributes&attribute.Bold > 0 && fg < 8 {
+		fg += 8
+	}
+	if b.Flags.NonBlink && t.Attributes&attribute.Blink > 0 && bg < 8 {
+		bg += 8
+	}
+	if t.Attributes&attribute.Negative > 0 {
+		fg, bg = bg, fg
+	}
+	return
+}
+
+// curlyUnderline are the vertical offsets of a curly underline, the wave
+// continues over adjacent tiles
+var curlyUnderline = [...]int{0, -1, 0, 1}
+
+// Blinking checks if the tile blinks, with iCE colors (NonBlink) the blink
+// attribute selects a bright background instead.
+func (b *Buffer) Blinking(t *Tile) bool {
+	return !b.Flags.NonBlink && t.Attributes&attribute.Blink > 0
+}
+
+// HasBlink checks if any of the visible tiles blink.
+func (b *Buffer) HasBlink() bool {
+	w, h := b.SizeMax()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if t := b.TileAt(x, y); t != nil && b.Blinking(t) && t.Char != 0x20 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Image returns the buffer as an image
 func (b *Buffer) Image(p palette.Palette, f *font.Font) (m image.Image, err error) {
+	return b.Render(p, f, nil)
+}
+
+// BlinkImage returns the buffer as an image in the blink phase where blinking
+// text is hidden. Alternating with Image every BlinkInterval animates it.
+func (b *Buffer) BlinkImage(p palette.Palette, f *font.Font) (m image.Image, err error) {
+	return b.Render(p, f, &RenderOptions{Blink: true})
+}
+
+func (b *Buffer) image(p palette.Palette, f *font.Font, o *RenderOptions) (m image.Image, err error) {
+	if o.Palette != nil {
+		p = o.Palette
+	}
+	if len(p) == 0 {
+		return nil, errEmptyPalette
+	}
+	fonts := font.NewSet(f)
+	if len(o.Fonts) > 1 {
+		fonts = append(fonts, o.Fonts[1:]...)
+	}
+	for n, alt := range fonts {
+		if alt != nil && alt.Size != f.Size {
+			return nil, fmt.Errorf("buffer: font %d is %dx%d, expected %dx%d", n, alt.Size.X, alt.Size.Y, f.Size.X, f.Size.Y)
+		}
+	}
 	w, h := b.SizeMax()
 
 	dx := f.Size.X
 	dy := f.Size.Y
-	if b.Flags.LetterSpacing&sauce.LetterSpacing9Pixel > 0 {
+	letterSpacing := b.Flags.LetterSpacing
+	if o.LetterSpacing != 0 {
+		letterSpacing = o.LetterSpacing
+	}
+	nine := letterSpacing == sauce.LetterSpacing9Pixel
+	if nine {
 		// Adjust for 9 pixel letter spacing
 		dx++
 	}
-	dp := image.Pt(dx+1, dy)
-	i := image.NewRGBA(image.Rect(0, 0, dx*w, dy*h))
 
-	colors := make([]*image.Uniform, len(p))
-	for i, c := range p {
-		colors[i] = image.NewUniform(c)
+	n := len(p)
+	index := func(c int) int {
+		// Out of range colors wrap around the palette
+		return (c%n + n) % n
 	}
 
-	/*
-		log.Printf("buffer: draw %d x %d image for %d x %d buffer with %d colors\n",
-			i.Rect.Max.X, i.Rect.Max.Y, w, h, len(colors))
-	*/
+	// Faint colors are added to the palette, before the canvas picks its
+	// color model
+	faint := make(map[int]int)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if t := b.TileAt(x, y); t != nil && t.Attributes&attribute.Faint > 0 {
+				fg, _ := b.TileColors(t)
+				if _, ok := faint[index(fg)]; !ok {
+					if len(faint) == 0 {
+						p = p.Copy()
+					}
+					faint[index(fg)] = len(p)
+					p = append(p, halfIntensity(p[index(fg)]))
+				}
+			}
+		}
+	}
+
+	// Start with a canvas in the first color, usually black, or in the
+	// background color of the options
+	base := 0
+	if o.Background != nil {
+		if len(faint) == 0 {
+			p = p.Copy()
+		}
+		base = len(p)
+		p = append(p, o.Background)
+	}
+	i := newCanvas(image.Rect(0, 0, dx*w, dy*h), p, base)
 
-	// Start with a black canvas
-	draw.Draw(i, i.Bounds(), colors[0], image.ZP, draw.Src)
 	for y := 0; y < h; y++ {
 		for x := 0; x < w; x++ {
 			ox := x * dx
 			oy := y * dy
 
 			t := b.TileAt(x, y)
+			if t == nil {
+				// Past the end of the buffer
+				continue
+			}
 
 			p := image.Pt(ox, oy)
-			r := image.Rectangle{p, p.Add(dp)}
+			cell := image.Rect(ox, oy, ox+dx, oy+dy)
 
-			fg := t.Color
-			bg := t.Background
-			if t.Attributes&attribute.Bold > 0 && fg < 8 {
-				fg += 8
-			}
-			if b.Flags.NonBlink && t.Attributes&attribute.Blink > 0 && bg < 8 {
-				bg += 8
-			}
-			if t.Attributes&attribute.Negative == attribute.Negative {
-				fg, bg = bg, fg
-			}
+			fg, bg := b.TileColors(t)
+			fg, bg = index(fg), index(bg)
 
 			// Background
 			if bg > 0 {
-				draw.Draw(i, r, colors[bg], image.ZP, draw.Src)
+				fill(i, cell, bg)
+			}
+
+			if o.Blink && b.Blinking(t) || t.Attributes&attribute.Conceal > 0 {
+				// Only the background is visible
+				continue
+			}
+			visible := fg != bg
+			if t.Attributes&attribute.Faint > 0 {
+				fg = faint[fg]
 			}
 
 			// Foreground
-			if fg != bg && t.Char != 0x20 {
-				mr := f.BoundsFor(t.Char)
-				draw.DrawMask(i, mr.Sub(mr.Min).Add(p), colors[fg], image.ZP, f.Mask, mr.Min, draw.Over)
+			if visible && t.Char != 0x20 {
+				italic := t.Attributes&attribute.Italics > 0
+				wide := nine && repeatsColumn(t.Char)
+				f := fonts.Font(t.Font)
+				glyph(i, f, t.Char, p, fg, italic, wide)
+				if b.BoldFont && t.Attributes&attribute.Bold > 0 {
+					// Overstrike the glyph one pixel to the right
+					glyph(i, f, t.Char, p.Add(image.Pt(1, 0)), fg, italic, wide)
+				}
 			}
 
-			if t.Attributes&attribute.Underline > 0 {
+			// Lines
+			uc := fg
+			if t.UnderlineColor != DefaultUnderlineColor {
+				uc = index(t.UnderlineColor)
+			}
+			switch {
+			case t.Attributes&attribute.CurlyUnderline > 0:
 				for xx := ox; xx < ox+dx; xx++ {
-					i.Set(xx, oy+f.Size.Y, colors[fg])
+					i.set(xx, oy+dy-2+curlyUnderline[xx%len(curlyUnderline)], uc)
 				}
+			case t.Attributes&attribute.DoubleUnderline > 0:
+				hline(i, cell, dy-1, uc)
+				hline(i, cell, dy-3, uc)
+			case t.Attributes&attribute.Underline > 0:
+				hline(i, cell, dy-1, uc)
+			}
+			if t.Attributes&attribute.CrossedOut > 0 {
+				hline(i, cell, dy/2, fg)
+			}
+			if t.Attributes&attribute.Overline > 0 {
+				hline(i, cell, 0, fg)
+			}
+
+			// Frames join up with framed neighbours
+			if t.Attributes&attribute.Frame > 0 {
+				hline(i, cell, 0, fg)
+				hline(i, cell, dy-1, fg)
+				if x == 0 || !b.framed(x-1, y) {
+					vline(i, cell, 0, fg)
+				}
+				if x == w-1 || !b.framed(x+1, y) {
+					vline(i, cell, dx-1, fg)
+				}
+			}
+			if t.Attributes&attribute.Encircle > 0 {
+				ellipse(i, cell, fg)
 			}
 		}
 	}
 
-	return i, nil
+	if s := b.AspectRatio(dx); s != 1 {
+		return stretch(i.unwrap(), s, b.Resampling), nil
+	}
+	return i.unwrap(), nil
 }
@@ -0,0 +1,86 @@
+package buffer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSizeMax(t *testing.T) {
+	tests := []struct {
+		Name string
+		Put  func(b *Buffer)
+		W, H int
+	}{
+		{"empty", func(b *Buffer) {}, 0, 0},
+		{"one line", func(b *Buffer) {
+			for _, c := range []byte("abc") {
+				b.PutChar(c)
+			}
+		}, 3, 1},
+		{"last row", func(b *Buffer) {
+			b.PutChar('a')
+			b.Cursor.X, b.Cursor.Y = 0, 1
+			b.PutChar('b')
+		}, 1, 2},
+		{"last column", func(b *Buffer) {
+			for i := 0; i < 4; i++ {
+				b.PutChar('a')
+			}
+		}, 4, 1},
+	}
+	for _, test := range tests {
+		b := New(4, 1)
+		test.Put(b)
+		if w, h := b.SizeMax(); w != test.W || h != test.H {
+			t.Errorf("%s: expected %dx%d, got %dx%d", test.Name, test.W, test.H, w, h)
+		}
+	}
+}
+
+func TestEditing(t *testing.T) {
+	tests := []struct {
+		Name string
+		Edit func(b *Buffer)
+		Want string
+	}{
+		{"insert", func(b *Buffer) { b.InsertChars(1, 0, 2) }, "a  b|def"},
+		{"insert past the end", func(b *Buffer) { b.InsertChars(2, 0, 9) }, "ab|def"},
+		{"delete", func(b *Buffer) { b.DeleteChars(0, 1, 2) }, "abc|f"},
+		{"erase", func(b *Buffer) { b.EraseChars(1, 1, 9) }, "abc|d"},
+		{"delete line", func(b *Buffer) { b.DeleteLines(0, 1) }, "def|"},
+		{"past the end", func(b *Buffer) {
+			b.InsertChars(0, 9, 1)
+			b.DeleteChars(0, 9, 1)
+			b.EraseChars(0, 9, 1)
+			b.DeleteLines(9, 1)
+		}, "abc|def"},
+	}
+	for _, test := range tests {
+		b := New(4, 2)
+		write(b, "abc")
+		b.Cursor.X, b.Cursor.Y = 0, 1
+		write(b, "def")
+		test.Edit(b)
+		if got := text(b); got != test.Want {
+			t.Errorf("%s: expected %q, got %q", test.Name, test.Want, got)
+		}
+	}
+}
+
+// text returns the used rows of the buffer, separated by a pipe
+func text(b *Buffer) string {
+	w, h := b.SizeMax()
+	lines := make([]string, h)
+	for y := range lines {
+		var line []byte
+		for x := 0; x < w; x++ {
+			if o := y*b.Width + x; o < len(b.Tiles) && b.Tiles[o] != nil {
+				line = append(line, b.Tiles[o].Char)
+			} else {
+				line = append(line, ' ')
+			}
+		}
+		lines[y] = strings.TrimRight(string(line), " ")
+	}
+	return strings.Join(lines, "|")
+}
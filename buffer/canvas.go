@@ -0,0 +1,145 @@
+package buffer
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// MaxPaletted is the maximum number of colors of a paletted image
+const MaxPaletted = 256
+
+// canvas is an image that is drawn with palette indices
+type canvas interface {
+	image.Image
+
+	// set pixel x, y to palette index c
+	set(x, y, c int)
+
+	// unwrap returns the image
+	unwrap() image.Image
+}
+
+// newCanvas returns a paletted canvas if the palette fits, or an RGBA canvas
+// otherwise. The canvas is filled with color c.
+func newCanvas(r image.Rectangle, p palette.Palette, c int) canvas {
+	var m canvas
+	if len(p) <= MaxPaletted {
+		m = palettedCanvas{image.NewPaletted(r, color.Palette(p))}
+		if c == 0 {
+			// Already filled with the first color
+			return m
+		}
+	} else {
+		rgba := rgbaCanvas{
+			RGBA:   image.NewRGBA(r),
+			colors: make([]color.RGBA, len(p)),
+		}
+		for i, c := range p {
+			rgba.colors[i] = color.RGBAModel.Convert(c).(color.RGBA)
+		}
+		m = rgba
+	}
+	fill(m, r, c)
+	return m
+}
+
+type palettedCanvas struct {
+	*image.Paletted
+}
+
+func (m palettedCanvas) set(x, y, c int) {
+	m.SetColorIndex(x, y, uint8(c))
+}
+
+func (m palettedCanvas) unwrap() image.Image { return m.Paletted }
+
+type rgbaCanvas struct {
+	*image.RGBA
+	colors []color.RGBA
+}
+
+func (m rgbaCanvas) set(x, y, c int) {
+	m.SetRGBA(x, y, m.colors[c])
+}
+
+func (m rgbaCanvas) unwrap() image.Image { return m.RGBA }
+
+// fill rectangle r with color c
+func fill(m canvas, r image.Rectangle, c int) {
+	r = r.Intersect(m.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.set(x, y, c)
+		}
+	}
+}
+
+// repeatsColumn reports if VGA hardware repeats the eighth column of character
+// ch in the ninth, so the line drawing characters 0xc0-0xdf join up
+func repeatsColumn(ch byte) bool {
+	return ch >= 0xc0 && ch <= 0xdf
+}
+
+// glyph draws character ch of font f at p in color c, italic glyphs are
+// sheared to the right at the top. A wide glyph repeats its last column.
+func glyph(m canvas, f *font.Font, ch byte, p image.Point, c int, italic, wide bool) {
+	mr := f.BoundsFor(ch)
+	bounds := m.Bounds()
+	for y := mr.Min.Y; y < mr.Max.Y; y++ {
+		var shift int
+		if italic {
+			shift = (mr.Max.Y-1-y)*3/mr.Dy() - 1
+		}
+		for x := mr.Min.X; x < mr.Max.X; x++ {
+			if _, _, _, a := f.Mask.At(x, y).RGBA(); a == 0 {
+				continue
+			}
+			if q := p.Add(image.Pt(x+shift, y).Sub(mr.Min)); q.In(bounds) {
+				m.set(q.X, q.Y, c)
+			}
+			if q := p.Add(image.Pt(x+shift+1, y).Sub(mr.Min)); wide && x == mr.Max.X-1 && q.In(bounds) {
+				m.set(q.X, q.Y, c)
+			}
+		}
+	}
+}
+
+// hline draws row y of cell r in color c
+func hline(m canvas, r image.Rectangle, y, c int) {
+	fill(m, image.Rect(r.Min.X, r.Min.Y+y, r.Max.X, r.Min.Y+y+1), c)
+}
+
+// vline draws column x of cell r in color c
+func vline(m canvas, r image.Rectangle, x, c int) {
+	fill(m, image.Rect(r.Min.X+x, r.Min.Y, r.Min.X+x+1, r.Max.Y), c)
+}
+
+// ellipse draws the outline of the ellipse that fits cell r in color c
+func ellipse(m canvas, r image.Rectangle, c int) {
+	var (
+		bounds = m.Bounds()
+		rx     = float64(r.Dx()) / 2
+		ry     = float64(r.Dy()) / 2
+	)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// Distance of the pixel center from the outline, in pixels
+			dx := (float64(x-r.Min.X) + .5 - rx) / rx
+			dy := (float64(y-r.Min.Y) + .5 - ry) / ry
+			d := (math.Sqrt(dx*dx+dy*dy) - 1) * math.Min(rx, ry)
+			if d > -1 && d <= 0 && image.Pt(x, y).In(bounds) {
+				m.set(x, y, c)
+			}
+		}
+	}
+}
+
+// halfIntensity returns color c at half intensity, for faint text
+func halfIntensity(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{uint16(r / 2), uint16(g / 2), uint16(b / 2), uint16(a)}
+}
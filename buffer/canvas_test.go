@@ -0,0 +1,162 @@
+package buffer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+// write puts the characters of s at the cursor
+func write(b *Buffer, s string) {
+	for _, c := range []byte(s) {
+		b.PutChar(c)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	// A framed tile in the first column, next to an unused tile
+	b := New(2, 1)
+	b.Cursor.Attributes = attribute.Frame
+	write(b, "x")
+	m, err := b.Image(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := m.(*image.Paletted)
+	for _, x := range []int{0, 7} {
+		if c := i.ColorIndexAt(x, 8); c != DefaultColor {
+			t.Errorf("expected a frame at %d,8, got %d", x, c)
+		}
+	}
+	if b.Tiles[1] != nil {
+		t.Error("expected the unused neighbour not to be allocated")
+	}
+}
+
+func TestPalettedImage(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	b := New(80, 1)
+	b.Cursor.Color, b.Cursor.Background = 1, 4
+	write(b, "A")
+	m, err := b.Image(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, ok := m.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected paletted image, got %T", m)
+	}
+	if len(i.Palette) != len(palette.CGA) || i.ColorIndexAt(0, 0) != 4 {
+		t.Errorf("expected the CGA palette with a blue background, got %d colors and index %d",
+			len(i.Palette), i.ColorIndexAt(0, 0))
+	}
+
+	// Too many colors for a paletted image
+	p := make(palette.Palette, 300)
+	for c := range p {
+		p[c] = color.RGBA{uint8(c / 256), uint8(c), 0, 0xff}
+	}
+	b = New(80, 1)
+	b.Cursor.Color, b.Cursor.Background = 299, 0
+	write(b, "#")
+	if m, err = b.Image(p, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*image.RGBA); !ok {
+		t.Errorf("expected RGBA image for %d colors, got %T", len(p), m)
+	}
+	if c := color.RGBAModel.Convert(m.At(0, 0)); c != p[0] {
+		t.Errorf("expected the first color as background, got %v", c)
+	}
+}
+
+func TestAttributeImage(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	b := New(80, 1)
+	for _, c := range []struct {
+		Attributes uint32
+		Color      int
+		Text       string
+	}{
+		{attribute.Faint, 1, "\xdb"},
+		{attribute.CrossedOut, DefaultColor, " "},
+		{attribute.Conceal, DefaultColor, "\xdb"},
+		{attribute.Overline, DefaultColor, " "},
+		{attribute.Frame, DefaultColor, "  "},
+	} {
+		b.Cursor.Attributes, b.Cursor.Color = c.Attributes, c.Color
+		write(b, c.Text)
+	}
+	m, err := b.Image(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := m.(*image.Paletted)
+	if len(i.Palette) != len(palette.CGA)+1 {
+		t.Fatalf("expected a faint color in the palette, got %d colors", len(i.Palette))
+	}
+	tests := []struct {
+		Name string
+		X, Y int
+		Want uint8
+	}{
+		{"faint", 0, 0, uint8(len(palette.CGA))},
+		{"crossed-out", 8, 8, 7},
+		{"not crossed-out", 8, 0, 0},
+		{"concealed", 20, 8, 0},
+		{"overlined", 24, 0, 7},
+		{"framed top", 36, 0, 7},
+		{"framed left", 32, 8, 7},
+		{"framed joined", 39, 8, 0},
+		{"framed right", 47, 8, 7},
+	}
+	for _, test := range tests {
+		if c := i.ColorIndexAt(test.X, test.Y); c != test.Want {
+			t.Errorf("%s: expected color %d at %d,%d, got %d", test.Name, test.Want, test.X, test.Y, c)
+		}
+	}
+	r, _, _, _ := i.Palette[len(palette.CGA)].RGBA()
+	if cr, _, _, _ := palette.CGA[1].RGBA(); r != cr/2 {
+		t.Errorf("expected faint red at half intensity, got %#x", r)
+	}
+}
+
+func TestLetterSpacing(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	b := New(80, 1)
+	b.Flags.LetterSpacing = sauce.LetterSpacing9Pixel
+	write(b, "\xc4\xdb")
+	b.Cursor.Background = 4
+	write(b, "A")
+	m, err := b.Image(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := m.(*image.Paletted)
+	if w := i.Bounds().Dx(); w != 27 {
+		t.Fatalf("expected 3 cells of 9 pixels, got width %d", w)
+	}
+	for y := 0; y < 16; y++ {
+		// Line drawing characters repeat the eighth column
+		if a, b := i.ColorIndexAt(7, y), i.ColorIndexAt(8, y); a != b {
+			t.Errorf("row %d: expected column 8 repeated, got %d and %d", y, a, b)
+		}
+		// Other characters have a blank column in the background color
+		if c := i.ColorIndexAt(26, y); c != 4 {
+			t.Errorf("row %d: expected a blank ninth column, got %d", y, c)
+		}
+	}
+	if c := i.ColorIndexAt(17, 0); c != 7 {
+		t.Errorf("expected the full block to join up, got %d", c)
+	}
+}
@@ -15,6 +15,8 @@ func NewCursor(x, y int) *Cursor {
 			Char:       DefaultChar,
 			Color:      DefaultColor,
 			Background: DefaultBackground,
+
+			UnderlineColor: DefaultUnderlineColor,
 		},
 	}
 }
@@ -0,0 +1,157 @@
+package buffer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/math"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// HTMLOptions configure how a buffer is written as HTML.
+type HTMLOptions struct {
+	// Head is added to the head of a full document, such as a title or a
+	// style sheet link.
+	Head string
+
+	// Color and Background are the palette indices of the space that is not
+	// covered by tiles.
+	Color, Background int
+
+	// Char returns the HTML for a character, the HTML special characters are
+	// escaped before. If not set, printable ASCII is written as-is and other
+	// characters as a reference to the code point with the same value, for
+	// use with the cp437.css font.
+	Char func(c byte) string
+}
+
+// HTML returns the buffer as HTML with palette p, if full is set a complete
+// document is returned. If o is nil the defaults are used.
+func (b *Buffer) HTML(p palette.Palette, full bool, o *HTMLOptions) string {
+	if o == nil {
+		o = &HTMLOptions{Color: DefaultColor, Background: DefaultBackground}
+	}
+
+	var s strings.Builder
+	if full {
+		s.WriteString("<!doctype html>\n")
+		s.WriteString(o.Head)
+	}
+	a := randomPrefix(3)
+	s.WriteString("<style type=\"text/css\">\n")
+	for i := 0; i < len(p); i++ {
+		r, g, b, _ := p[i].RGBA()
+		c := fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+		fmt.Fprintf(&s, ".f%s%02x{color:%s} ", a, i, c)
+		fmt.Fprintf(&s, ".b%s%02x{background-color:%s} ", a, i, c)
+		fmt.Fprintf(&s, ".u%s%02x{text-decoration-color:%s}", a, i, c)
+		s.WriteString("\n")
+	}
+	s.WriteString(`.b{font-weight:bold} .i{font-style:italic} .s{text-decoration:line-through} `)
+	s.WriteString(`.u{text-decoration:underline} .ud{text-decoration:underline double} .uc{text-decoration:underline wavy} `)
+	s.WriteString(`.s.u,.s.ud,.s.uc{text-decoration-line:underline line-through}`)
+	fmt.Fprintf(&s, "\n.bl{animation:bl %dms step-end infinite} @keyframes bl{50%%{color:transparent}}",
+		2*BlinkInterval/time.Millisecond)
+	s.WriteString("</style>")
+	if full {
+		s.WriteString(`<pre>`)
+	}
+	fmt.Fprintf(&s, `<span class="b%s%02x f%s%02x">`, a, o.Background, a, o.Color)
+
+	w, h := b.SizeMax()
+	var l *Tile
+
+	for i, t := range b.Tiles {
+		y, x := math.DivMod(i, b.Width)
+		if x >= w {
+			continue
+		}
+		if y >= h {
+			break
+		}
+		if x == 0 && y > 0 {
+			s.WriteString("\n")
+		}
+		if t == nil {
+			s.WriteString(" ")
+			continue
+		}
+		if !t.Equal(l) {
+			s.WriteString(`</span>`)
+			fmt.Fprintf(&s, `<span class="%s">`, strings.Join(b.htmlClass(a, t), " "))
+		}
+		s.WriteString(o.char(t.Char))
+		l = t
+	}
+
+	s.WriteString(`</span>`)
+	if full {
+		s.WriteString(`</pre>`)
+	}
+	return s.String()
+}
+
+// htmlClass returns the style classes of a tile, a is the prefix of the
+// palette classes.
+func (b *Buffer) htmlClass(a string, t *Tile) (c []string) {
+	f, bg := b.TileColors(t)
+	c = append(c, fmt.Sprintf("b%s%02x", a, bg))
+	c = append(c, fmt.Sprintf("f%s%02x", a, f))
+	if b.BoldFont && t.Attributes&attribute.Bold > 0 {
+		c = append(c, "b")
+	}
+	if t.Attributes&attribute.Italics > 0 {
+		c = append(c, "i")
+	}
+	if t.Attributes&attribute.CrossedOut > 0 {
+		c = append(c, "s")
+	}
+	if b.Blinking(t) {
+		c = append(c, "bl")
+	}
+	if t.Attributes&attribute.Underline > 0 {
+		c = append(c, "u")
+	}
+	if t.Attributes&attribute.DoubleUnderline > 0 {
+		c = append(c, "ud")
+	}
+	if t.Attributes&attribute.CurlyUnderline > 0 {
+		c = append(c, "uc")
+	}
+	if t.UnderlineColor != DefaultUnderlineColor {
+		c = append(c, fmt.Sprintf("u%s%02x", a, t.UnderlineColor))
+	}
+	return
+}
+
+// char returns the HTML for a character
+func (o *HTMLOptions) char(c byte) string {
+	switch c {
+	case '&':
+		return "&amp;"
+	case '<':
+		return "&lt;"
+	case '>':
+		return "&gt;"
+	}
+	if o.Char != nil {
+		return o.Char(c)
+	}
+	if c >= 0x20 && c < 0x7f {
+		return string(c)
+	}
+	return fmt.Sprintf(`&#x%02x;`, c)
+}
+
+// randomPrefix returns a random class name prefix, so the styles of multiple
+// buffers on a page don't collide.
+func randomPrefix(size int) string {
+	var buf = make([]byte, size)
+	io.ReadFull(rand.Reader, buf)
+	return hex.EncodeToString(buf)[:size]
+}
@@ -0,0 +1,39 @@
+package buffer
+
+import (
+	"strings"
+	"testing"
+
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+func TestHTML(t *testing.T) {
+	b := New(80, 1)
+	b.BoldFont = true
+	b.Cursor.Attributes = attribute.Bold | attribute.CrossedOut | attribute.Underline
+	write(b, "<a&b>")
+	b.Cursor.Attributes = attribute.Blink
+	write(b, "\x01")
+
+	s := b.HTML(palette.CGA, true, &HTMLOptions{Head: "<title>x</title>\n"})
+	for _, want := range []string{
+		"<!doctype html>\n<title>x</title>\n",
+		` b s u">&lt;a&amp;b&gt;</span>`,
+		` bl">&#x01;</span>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in %q", want, s)
+		}
+	}
+
+	// Without a bold font, bold is a bright color
+	b.BoldFont = false
+	s = b.HTML(palette.CGA, false, &HTMLOptions{Char: func(c byte) string { return "?" }})
+	if strings.Contains(s, " b ") || strings.Contains(s, "doctype") {
+		t.Errorf("expected a fragment without bold class, got %q", s)
+	}
+	if !strings.Contains(s, `">?</span>`) {
+		t.Errorf("expected the characters of the options, got %q", s)
+	}
+}
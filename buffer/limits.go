@@ -0,0 +1,90 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is wrapped by all limit errors.
+var ErrLimitExceeded = errors.New("buffer: limit exceeded")
+
+// Limits restricts the resources used for a buffer, a zero or negative value
+// is no limit. The parsers replace the zero values with DefaultLimits, see
+// WithDefaults.
+type Limits struct {
+	Width  int // Maximum number of columns
+	Height int // Maximum number of rows
+	Tiles  int // Maximum number of tiles
+	Colors int // Maximum number of palette entries
+}
+
+// DefaultLimits are used by the parsers if no limits are set, they are well
+// above the size of the largest pieces.
+var DefaultLimits = Limits{
+	Width:  4096,
+	Height: 65536,
+	Tiles:  1 << 21,
+	Colors: 1 << 16,
+}
+
+// WithDefaults returns the limits, with the zero limits taken from
+// DefaultLimits. Negative limits are kept, to turn a limit off.
+func (l Limits) WithDefaults() Limits {
+	if l.Width == 0 {
+		l.Width = DefaultLimits.Width
+	}
+	if l.Height == 0 {
+		l.Height = DefaultLimits.Height
+	}
+	if l.Tiles == 0 {
+		l.Tiles = DefaultLimits.Tiles
+	}
+	if l.Colors == 0 {
+		l.Colors = DefaultLimits.Colors
+	}
+	return l
+}
+
+// LimitError is returned if a limit is exceeded.
+type LimitError struct {
+	Limit      string
+	Value, Max int
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("buffer: %s %d exceeds limit of %d", err.Limit, err.Value, err.Max)
+}
+
+// Unwrap returns ErrLimitExceeded.
+func (err *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Check if a w x h canvas is within the limits.
+func (l Limits) Check(w, h int) error {
+	switch {
+	case l.Width > 0 && w > l.Width:
+		return &LimitError{"width", w, l.Width}
+	case l.Height > 0 && h > l.Height:
+		return &LimitError{"height", h, l.Height}
+	case l.Tiles > 0 && w*h > l.Tiles:
+		return &LimitError{"tiles", w * h, l.Tiles}
+	}
+	return nil
+}
+
+// CheckTiles checks if n tiles are within the limits.
+func (l Limits) CheckTiles(n int) error {
+	if l.Tiles > 0 && n > l.Tiles {
+		return &LimitError{"tiles", n, l.Tiles}
+	}
+	return nil
+}
+
+// CheckColors checks if a palette of n colors is within the limits.
+func (l Limits) CheckColors(n int) error {
+	if l.Colors > 0 && n > l.Colors {
+		return &LimitError{"colors", n, l.Colors}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package buffer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	l := Limits{Width: -1, Tiles: 100}.WithDefaults()
+	if l.Width != -1 || l.Height != DefaultLimits.Height || l.Tiles != 100 || l.Colors != DefaultLimits.Colors {
+		t.Fatalf("expected the zero limits to be filled in, got %+v", l)
+	}
+	tests := []struct {
+		W, H int
+		Err  bool
+	}{
+		{100, 1, false},
+		{10, 10, false},
+		{10, 11, true},
+		{1, DefaultLimits.Height + 1, true},
+	}
+	for _, test := range tests {
+		if err := l.Check(test.W, test.H); test.Err != errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%dx%d: unexpected error %v", test.W, test.H, err)
+		}
+	}
+	if err := (Limits{Width: -1, Height: -1, Tiles: -1}).WithDefaults().Check(1<<20, 1<<20); err != nil {
+		t.Errorf("expected no limits, got %v", err)
+	}
+}
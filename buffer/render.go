@@ -0,0 +1,181 @@
+package buffer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// RenderOptions configure how a buffer is rendered as image. The zero value
+// renders the buffer at the size of the font.
+type RenderOptions struct {
+	// Palette overrides the palette.
+	Palette palette.Palette
+
+	// LetterSpacing overrides the letter spacing of the buffer, using the
+	// SAUCE letter spacing constants.
+	LetterSpacing uint8
+
+	// Background replaces the first palette color where no background color
+	// is set, such as color.Transparent or a color key. Text in the first
+	// color is not affected.
+	Background color.Color
+
+	// Scale the image, integer scales repeat pixels and keep the palette,
+	// fractional scales are box filtered.
+	Scale float64
+
+	// Thumbnail box filters the image to fit in a box of this size, keeping
+	// its aspect ratio. A zero width or height is not limited. Thumbnail
+	// takes precedence over Scale.
+	Thumbnail image.Point
+
+	// Fonts draws every tile with the font selected by its Font index. The
+	// font passed to Render is the primary font, font 0 of the set is
+	// ignored. All fonts must have the size of the primary font.
+	Fonts font.Set
+
+	// Blink renders the blink phase, where blinking text is hidden.
+	Blink bool
+}
+
+// size returns the size of an image of w x h pixels after scaling
+func (o *RenderOptions) size(w, h int) (int, int) {
+	s := o.Scale
+	if t := o.Thumbnail; t.X > 0 || t.Y > 0 {
+		s = math.Inf(1)
+		if t.X > 0 {
+			s = float64(t.X) / float64(w)
+		}
+		if t.Y > 0 {
+			s = math.Min(s, float64(t.Y)/float64(h))
+		}
+	}
+	if s <= 0 || s == 1 {
+		return w, h
+	}
+	return int(math.Max(1, math.Round(float64(w)*s))), int(math.Max(1, math.Round(float64(h)*s)))
+}
+
+// Render returns the buffer as an image, with options o. If o is nil the
+// defaults are used.
+func (b *Buffer) Render(p palette.Palette, f *font.Font, o *RenderOptions) (m image.Image, err error) {
+	if o == nil {
+		o = new(RenderOptions)
+	}
+	if m, err = b.image(p, f, o); err != nil {
+		return
+	}
+	r := m.Bounds()
+	w, h := o.size(r.Dx(), r.Dy())
+	return resize(m, w, h), nil
+}
+
+// resize scales image m to w x h pixels. Integer scales repeat pixels and keep
+// the color model, other sizes are box filtered.
+func resize(m image.Image, w, h int) image.Image {
+	r := m.Bounds()
+	switch {
+	case r.Empty(), w == r.Dx() && h == r.Dy():
+		return m
+	case w%r.Dx() == 0 && h%r.Dy() == 0:
+		return repeat(m, w/r.Dx(), h/r.Dy())
+	default:
+		return box(m, w, h)
+	}
+}
+
+// repeat every pixel of m sx times horizontally and sy times vertically
+func repeat(m image.Image, sx, sy int) image.Image {
+	var (
+		r = m.Bounds()
+		d = image.Rect(0, 0, r.Dx()*sx, r.Dy()*sy)
+	)
+	if m, ok := m.(*image.Paletted); ok {
+		i := image.NewPaletted(d, m.Palette)
+		for y := d.Min.Y; y < d.Max.Y; y++ {
+			for x := d.Min.X; x < d.Max.X; x++ {
+				i.Pix[i.PixOffset(x, y)] = m.ColorIndexAt(r.Min.X+x/sx, r.Min.Y+y/sy)
+			}
+		}
+		return i
+	}
+	i := image.NewRGBA(d)
+	for y := d.Min.Y; y < d.Max.Y; y++ {
+		for x := d.Min.X; x < d.Max.X; x++ {
+			i.Set(x, y, m.At(r.Min.X+x/sx, r.Min.Y+y/sy))
+		}
+	}
+	return i
+}
+
+// span is the weight of a source pixel in a destination pixel
+type span struct {
+	i int
+	w float64
+}
+
+// boxSpans returns the source pixels covered by each of m destination pixels,
+// when scaling n source pixels
+func boxSpans(n, m int) [][]span {
+	var (
+		spans = make([][]span, m)
+		scale = float64(n) / float64(m)
+	)
+	for d := range spans {
+		x0 := float64(d) * scale
+		x1 := x0 + scale
+		for i := int(x0); i < n && float64(i) < x1; i++ {
+			if cover := math.Min(x1, float64(i+1)) - math.Max(x0, float64(i)); cover > 0 {
+				spans[d] = append(spans[d], span{i, cover / scale})
+			}
+		}
+	}
+	return spans
+}
+
+// box scales image m to w x h pixels, every destination pixel is the average
+// of the source area it covers
+func box(m image.Image, w, h int) image.Image {
+	r := m.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(src, src.Bounds(), m, r.Min, draw.Src)
+
+	// Scale the rows, then the columns
+	var (
+		xs   = boxSpans(r.Dx(), w)
+		ys   = boxSpans(r.Dy(), h)
+		rows = make([][4]float64, w*r.Dy())
+		i    = image.NewRGBA(image.Rect(0, 0, w, h))
+	)
+	for y := 0; y < r.Dy(); y++ {
+		for x, spans := range xs {
+			c := &rows[y*w+x]
+			for _, s := range spans {
+				o := src.PixOffset(s.i, y)
+				for k := range c {
+					c[k] += float64(src.Pix[o+k]) * s.w
+				}
+			}
+		}
+	}
+	for y, spans := range ys {
+		for x := 0; x < w; x++ {
+			var c [4]float64
+			for _, s := range spans {
+				for k, v := range rows[s.i*w+x] {
+					c[k] += v * s.w
+				}
+			}
+			o := i.PixOffset(x, y)
+			for k, v := range c {
+				i.Pix[o+k] = uint8(math.Min(255, math.Round(v)))
+			}
+		}
+	}
+	return i
+}
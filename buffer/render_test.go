@@ -0,0 +1,169 @@
+package buffer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+func TestBlink(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	b := New(80, 1)
+	write(b, "a")
+	b.Cursor.Attributes = attribute.Blink
+	write(b, "b")
+	if !b.HasBlink() {
+		t.Fatal("expected blinking text")
+	}
+	on, err := b.Image(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, err := b.BlinkImage(palette.CGA, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed image.Rectangle
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if on.At(x, y) != off.At(x, y) {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if changed.Empty() || changed.Min.X < 8 {
+		t.Errorf("expected only the blinking tile to change, got %v", changed)
+	}
+
+	// Blinking spaces are not visible
+	b = New(80, 1)
+	b.Cursor.Attributes = attribute.Blink
+	write(b, " ")
+	if b.HasBlink() {
+		t.Error("expected no visible blinking text")
+	}
+
+	// With iCE colors blink selects a bright background
+	b = New(80, 1)
+	b.Flags.NonBlink = true
+	b.Cursor.Attributes = attribute.Blink
+	write(b, "b")
+	if b.HasBlink() {
+		t.Error("expected no blinking text with iCE colors")
+	}
+	if _, bg := b.TileColors(b.TileAt(0, 0)); bg != 8 {
+		t.Errorf("expected bright background, got %d", bg)
+	}
+}
+
+func TestRender(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	b := New(80, 1)
+	b.Cursor.Color = 1
+	write(b, "A")
+	b.Cursor.Background = 4
+	write(b, " ")
+
+	green := color.RGBA{G: 0xff, A: 0xff}
+	pal := palette.CGA.Copy()
+	pal[4] = green
+
+	tests := []struct {
+		Name     string
+		Options  *RenderOptions
+		Size     image.Point
+		Paletted bool
+		At       image.Point
+		Want     color.Color
+	}{
+		{"nil", nil, image.Pt(16, 16), true, image.Pt(0, 0), palette.CGA[0]},
+		{"default", &RenderOptions{}, image.Pt(16, 16), true, image.Pt(0, 0), palette.CGA[0]},
+		{"retina", &RenderOptions{Scale: 2}, image.Pt(32, 32), true, image.Pt(31, 31), palette.CGA[4]},
+		{"fractional", &RenderOptions{Scale: 1.5}, image.Pt(24, 24), false, image.Pt(23, 23), palette.CGA[4]},
+		{"thumbnail", &RenderOptions{Thumbnail: image.Pt(4, 100)}, image.Pt(4, 4), false, image.Pt(3, 0), palette.CGA[4]},
+		{"thumbnail height", &RenderOptions{Thumbnail: image.Pt(0, 8), Scale: 2}, image.Pt(8, 8), false, image.Pt(7, 0), palette.CGA[4]},
+		{"transparent", &RenderOptions{Background: color.Transparent}, image.Pt(16, 16), true, image.Pt(0, 0), color.Transparent},
+		{"color key", &RenderOptions{Background: green}, image.Pt(16, 16), true, image.Pt(0, 0), green},
+		{"palette", &RenderOptions{Palette: pal}, image.Pt(16, 16), true, image.Pt(8, 0), green},
+		{"letter spacing", &RenderOptions{LetterSpacing: sauce.LetterSpacing9Pixel}, image.Pt(18, 16), true, image.Pt(17, 0), palette.CGA[4]},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			m, err := b.Render(palette.CGA, f, test.Options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := m.Bounds().Size(); s != test.Size {
+				t.Errorf("expected size %s, got %s", test.Size, s)
+			}
+			if _, ok := m.(*image.Paletted); ok != test.Paletted {
+				t.Errorf("expected paletted %t, got %T", test.Paletted, m)
+			}
+			if c, want := color.RGBAModel.Convert(m.At(test.At.X, test.At.Y)), color.RGBAModel.Convert(test.Want); c != want {
+				t.Errorf("expected color %v at %s, got %v", want, test.At, c)
+			}
+		})
+	}
+}
+
+func TestBoxSpans(t *testing.T) {
+	for _, test := range []struct{ n, m int }{{16, 4}, {16, 24}, {3, 2}} {
+		for d, spans := range boxSpans(test.n, test.m) {
+			var w float64
+			for _, s := range spans {
+				w += s.w
+			}
+			if math.Abs(w-1) > 1e-9 {
+				t.Errorf("%d to %d: expected destination pixel %d to have weight 1, got %g", test.n, test.m, d, w)
+			}
+		}
+	}
+}
+
+func TestAlternateFonts(t *testing.T) {
+	var (
+		f   = font.Get("cp437", image.Pt(8, 16))
+		alt = font.Get("cp866", image.Pt(8, 16))
+	)
+
+	b := New(80, 1)
+	for _, n := range []int{0, 1, 0, 2} {
+		b.Cursor.Font = n
+		write(b, "\x80")
+	}
+	m, err := b.Render(palette.CGA, f, &RenderOptions{Fonts: font.NewSet(nil, alt)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := m.(*image.Paletted)
+	cell := func(x int) (s string) {
+		for y := 0; y < 16; y++ {
+			for dx := 0; dx < 8; dx++ {
+				s += fmt.Sprint(i.ColorIndexAt(x*8+dx, y))
+			}
+		}
+		return
+	}
+	if cell(0) == cell(1) {
+		t.Error("expected the alternate font in the second cell")
+	}
+	if cell(0) != cell(2) {
+		t.Error("expected the primary font in the third cell")
+	}
+	if cell(0) != cell(3) {
+		t.Error("expected the primary font for a font that is not in the set")
+	}
+
+	if _, err = b.Render(palette.CGA, f, &RenderOptions{Fonts: font.NewSet(nil, font.Get("cp437", image.Pt(8, 8)))}); err == nil {
+		t.Error("expected an error for an alternate font of a different size")
+	}
+}
@@ -10,6 +10,9 @@ const (
 	DefaultChar       = 0x20
 	DefaultColor      = 0x07
 	DefaultBackground = 0x00
+
+	// DefaultUnderlineColor draws the underline in the foreground color
+	DefaultUnderlineColor = -1
 )
 
 type Tile struct {
@@ -17,6 +20,10 @@ type Tile struct {
 	Color, Background int
 	Font              int
 	Attributes        uint32
+
+	// UnderlineColor is the palette index of the underline, or
+	// DefaultUnderlineColor
+	UnderlineColor int
 }
 
 func NewTile() *Tile {
@@ -28,7 +35,8 @@ func (t *Tile) Equal(o *Tile) bool {
 	if o == nil {
 		return false
 	}
-	return t.Color == o.Color && t.Background == o.Background && t.Attributes == o.Attributes
+	return t.Color == o.Color && t.Background == o.Background && t.Attributes == o.Attributes &&
+		t.UnderlineColor == o.UnderlineColor
 }
 
 func (t *Tile) Reset() *Tile {
@@ -42,6 +50,7 @@ func (t *Tile) ResetAttributes() *Tile {
 	t.Background = DefaultBackground
 	t.Font = 0
 	t.Attributes = attribute.None
+	t.UnderlineColor = DefaultUnderlineColor
 	return t
 }
 
@@ -55,5 +64,6 @@ func (t *Tile) Update(o *Tile) *Tile {
 	t.Color, t.Background = o.Color, o.Background
 	t.Font = o.Font
 	t.Attributes = o.Attributes
+	t.UnderlineColor = o.UnderlineColor
 	return t
 }
@@ -4,72 +4,155 @@ import (
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
+	"git.maze.io/maze/go-piece/animation"
+	"git.maze.io/maze/go-piece/buffer"
 	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/music"
+	"git.maze.io/maze/go-piece/palette"
 	"git.maze.io/maze/go-piece/parser"
 	"git.maze.io/maze/go-piece/parser/ansi"
 	"git.maze.io/maze/go-piece/parser/binarytext"
 	"git.maze.io/maze/go-piece/parser/irc"
 	"git.maze.io/maze/go-piece/parser/xbin"
 	sauce "git.maze.io/maze/go-sauce"
+
+	"golang.org/x/text/encoding/ianaindex"
 )
 
-var supportedParser = [][]string{
-	[]string{"ANSi/ASCII", "ansi", "ascii", "text"},
-	[]string{"Binary text (raw VGA page)", "bin", "binarytext"},
-	[]string{"IRC log with mIRC formatting", "irc", "mirc"},
-	[]string{"eXtended Binary text", "xbin"},
+// listParsers prints the registered formats.
+func listParsers() {
+	fmt.Fprintln(os.Stderr, "Supported parsers:")
+	for _, f := range parser.Formats() {
+		fmt.Fprintf(os.Stderr, "\n\t%s:\n", strings.Join(f.Names, ", "))
+		fmt.Fprintf(os.Stderr, "\t\t%s\n", f.Name)
+	}
+	fmt.Fprintln(os.Stderr, "")
 }
 
-func guessParser(filename, option string) parser.Parser {
-	if option != "" {
-		switch strings.ToLower(option) {
-		case "help", "list":
-			fmt.Fprintln(os.Stderr, "Supported parsers:")
-			for _, p := range supportedParser {
-				var (
-					o = p[0]
-					a = p[1:]
-				)
-				fmt.Fprintf(os.Stderr, "\n\t%s:\n", strings.Join(a, ", "))
-				fmt.Fprintf(os.Stderr, "\t\t%s\n", o)
-			}
-			fmt.Fprintln(os.Stderr, "")
-			return nil
-		case "ansi", "ascii", "text":
-			return ansi.New(80, 25)
-		case "bin", "binarytext":
-			return binarytext.New()
-		case "irc", "mirc":
-			return irc.New()
-		case "xbin":
-			return xbin.New()
-		}
+// parserPalette returns the palette used by the parser.
+func parserPalette(p parser.Parser) palette.Palette {
+	switch p := p.(type) {
+	case *ansi.ANSI:
+		return p.Palette
+	case *binarytext.BinaryText:
+		return p.Palette
+	case *irc.IRC:
+		return p.Palette
+	case *xbin.XBIN:
+		return p.Palette
 	}
+	return palette.CGA
+}
 
-	switch strings.ToLower(filepath.Ext(filename)) {
-	case ".asc", ".ans", ".txt", ".diz", ".lit":
-		return ansi.New(80, 25)
+// parserBuffer returns the buffer of the parser, or the screen with its
+// scrollback if history is set.
+func parserBuffer(p parser.Parser, history bool) *buffer.Buffer {
+	if p, ok := p.(*ansi.ANSI); ok && history {
+		return p.History()
+	}
+	return p.Buffer()
+}
 
-	case ".bin":
-		return binarytext.New()
+// parserFont returns the font of the parser, or the named font. If no font is
+// named, the default font is used.
+func parserFont(p parser.Parser, name, defaultName, size, defaultSize string) (*font.Font, error) {
+	if f := p.Font(); f != nil {
+		return f, nil
+	}
+	if name == "" {
+		name = defaultName
+	}
+	return getFont(name, size, defaultSize)
+}
 
-	case ".irc", ".log":
-		return irc.New()
+// newEncoder returns the animation encoder for a format, PNG frames are
+// written to the files named by the output pattern.
+func newEncoder(format, output string, o io.Writer, fps int) (animation.Encoder, error) {
+	switch format {
+	case "gif":
+		return animation.NewGIF(o), nil
+	case "y4m":
+		return animation.NewY4M(o, fps), nil
+	case "png":
+		if !strings.Contains(output, "%") {
+			return nil, fmt.Errorf("output %q is not a pattern, such as frame%%05d.png", output)
+		}
+		return animation.NewPNG(fps, func(index int) (io.WriteCloser, error) {
+			return os.Create(fmt.Sprintf(output, index))
+		}), nil
+	default:
+		return nil, fmt.Errorf("format %q can't be animated", format)
+	}
+}
+
+// encodeBlink writes a GIF that alternates between the image i and the blink
+// phase of the buffer.
+func encodeBlink(w io.Writer, b *buffer.Buffer, p palette.Palette, f *font.Font, o buffer.RenderOptions, i image.Image) error {
+	o.Blink = true
+	off, err := b.Render(p, f, &o)
+	if err != nil {
+		return err
+	}
+	e := animation.NewGIF(w)
+	e.Hold = buffer.BlinkInterval
+	e.Encode(i, 0)
+	e.Encode(off, buffer.BlinkInterval)
+	return e.Close()
+}
+
+// alternateFonts returns the primary font f with the named alternate fonts,
+// of the same size.
+func alternateFonts(f *font.Font, names string) (font.Set, error) {
+	set := font.NewSet(f)
+	if names == "" {
+		return set, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		alt := font.Get(name, f.Size)
+		if alt == nil {
+			return nil, fmt.Errorf("font %s %dx%d not found", name, f.Size.X, f.Size.Y)
+		}
+		set = append(set, alt)
+	}
+	return set, nil
+}
 
-	case ".xb":
-		return xbin.New()
+// parseColor parses a background color, either transparent or #rrggbb.
+func parseColor(s string) (color.Color, error) {
+	if strings.ToLower(s) == "transparent" {
+		return color.Transparent, nil
+	}
+	var c = color.RGBA{A: 0xff}
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return nil, fmt.Errorf("invalid color %q, expected transparent or #rrggbb", s)
 	}
+	return c, nil
+}
 
-	return nil
+// getFont returns a builtin font, the default size is used if no size is given.
+func getFont(name, size, defaultSize string) (*font.Font, error) {
+	if size == "" {
+		size = defaultSize
+	}
+	s, err := font.ParseSize(size)
+	if err != nil {
+		return nil, err
+	}
+	f := font.Get(name, s)
+	if f == nil {
+		return nil, fmt.Errorf("font %s %s not found", name, size)
+	}
+	return f, nil
 }
 
 func main() {
@@ -80,8 +163,48 @@ func main() {
 	fontSizeFlag := flag.String("font-size", "", "Font size override")
 	defaultFontFlag := flag.String("default-font", "cp437", "Default font")
 	defaultFontSizeFlag := flag.String("default-font-size", "8x16", "Default font size")
+	ircExtendedFlag := flag.Bool("irc-extended", false, "Use the extended mIRC colors for irc output")
+	widthFlag := flag.Int("width", 0, "Width in characters (default: from SAUCE or format)")
+	heightFlag := flag.Int("height", 0, "Height in characters (default: from SAUCE or format)")
+	screenFlag := flag.Bool("screen", false, "Emulate a fixed size screen that scrolls (ANSi only)")
+	historyFlag := flag.Bool("history", false, "Render the scrollback of the screen as well (image and irc formats)")
+	iceFlag := flag.Bool("ice", false, "Enable iCE colors (non-blink)")
+	strictFlag := flag.Bool("strict", false, "Fail on the first parse warning")
+	tabStopFlag := flag.Int("tab-stop", parser.DefaultTabStop, "Tab stop width")
+	maxWidthFlag := flag.Int("max-width", buffer.DefaultLimits.Width, "Maximum canvas width in characters, negative for no limit")
+	maxHeightFlag := flag.Int("max-height", buffer.DefaultLimits.Height, "Maximum canvas height in characters, negative for no limit")
+	maxTilesFlag := flag.Int("max-tiles", buffer.DefaultLimits.Tiles, "Maximum number of characters on the canvas, negative for no limit")
+	maxColorsFlag := flag.Int("max-colors", buffer.DefaultLimits.Colors, "Maximum number of palette colors, negative for no limit")
+	encodingFlag := flag.String("encoding", "", "Input encoding (default: format default)")
+	baudFlag := flag.Int("baud", 0, "Animate the display at a baud rate (gif, png and y4m formats, ANSi only)")
+	everyFlag := flag.Int("every", 0, "Animate with a frame every number of bytes (gif, png and y4m formats, ANSi only)")
+	fpsFlag := flag.Int("fps", 25, "Frames per second of an animation")
+	blinkFlag := flag.Bool("blink", false, "Animate blinking text (gif format)")
+	letterSpacingFlag := flag.Int("letter-spacing", 0, "Letter spacing of 8 or 9 pixels (default: from SAUCE)")
+	aspectFlag := flag.String("aspect", "", "Aspect ratio, square or legacy pixels (default: from SAUCE)")
+	smoothFlag := flag.Bool("smooth", false, "Smooth resampling for the legacy aspect ratio")
+	scaleFlag := flag.Float64("scale", 1, "Scale images, such as 2 for retina displays")
+	thumbnailFlag := flag.String("thumbnail", "", "Fit images in a thumbnail of <width>x<height> pixels, 0 is unlimited")
+	alternateFontsFlag := flag.String("alternate-fonts", "", "Comma separated alternate fonts 1-9, selected with SGR 11-19")
+	backgroundFlag := flag.String("background", "", "Background color of images, transparent or #rrggbb (default: palette)")
 	flag.Parse()
 
+	// An animation is rendered on a fixed size screen, a PNG animation is
+	// written to a sequence of files named by the output pattern
+	animate := *baudFlag > 0 || *everyFlag > 0
+	if animate {
+		*screenFlag = true
+	}
+	if *fpsFlag < 1 {
+		*fpsFlag = 1
+	}
+
+	switch strings.ToLower(*parserFlag) {
+	case "help", "list":
+		listParsers()
+		return
+	}
+
 	if len(flag.Args()) != 1 {
 		fmt.Fprintln(os.Stderr, "Error: missing filename")
 		flag.Usage()
@@ -104,9 +227,12 @@ func main() {
 		o = os.Stderr
 
 	default:
+		if animate && *formatFlag == "png" {
+			break
+		}
 		var of *os.File
 		if of, err = os.Create(*outputFlag); err != nil {
-			log.Fatalln("%s: error creating %s: %v\n", filename, *outputFlag, err)
+			log.Fatalf("%s: error creating %s: %v\n", filename, *outputFlag, err)
 		}
 		defer of.Close()
 		o = of
@@ -117,38 +243,116 @@ func main() {
 		panic(err)
 	}
 
-	var p parser.Parser
-	w, h := 80, 25
-	r := io.NewSectionReader(f, 0, i.Size())
-	s, err := sauce.Parse(r)
-	if err != nil && err != sauce.ErrNoRecord {
-		log.Printf("%s: failed to parse SAUCE: %v\n", filename, err)
+	var (
+		r = io.NewSectionReader(f, 0, i.Size())
+		t *parser.Format
+		s *sauce.SAUCE
+	)
+	switch *parserFlag {
+	case "":
+		if t, s, err = parser.Detect(filename, r); err != nil {
+			log.Fatalf("%s: no suitable parser found: %v\n", filename, err)
+		}
+	default:
+		if t, err = parser.Lookup(*parserFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+		if s, err = sauce.Parse(r); err != nil && err != sauce.ErrNoRecord {
+			log.Printf("%s: failed to parse SAUCE: %v\n", filename, err)
+		}
 	}
-	if s == nil || *parserFlag != "" {
-		p = guessParser(filename, *parserFlag)
-
-	} else {
-		switch s.DataType {
-		case sauce.DataTypeCharacter:
-			switch s.FileType {
-			case 0, 1:
-				w = int(s.TInfo[0])
-				p = ansi.New(w, h)
-			}
-
-		case sauce.DataTypeBinaryText:
-			p = binarytext.New()
-
-		case sauce.DataTypeXBIN:
-			p = xbin.New()
-
-		default:
-			log.Printf("%s: unsupported data type %s (%02x)\n", filename, s.DataTypeString(), s.DataType)
+	opts := &parser.Options{
+		Width:    *widthFlag,
+		Height:   *heightFlag,
+		Screen:   *screenFlag,
+		NonBlink: *iceFlag,
+		TabStop:  *tabStopFlag,
+		Strict:   *strictFlag,
+		Limits: buffer.Limits{
+			Width:  *maxWidthFlag,
+			Height: *maxHeightFlag,
+			Tiles:  *maxTilesFlag,
+			Colors: *maxColorsFlag,
+		},
+	}
+	switch *letterSpacingFlag {
+	case 0:
+	case 8:
+		opts.LetterSpacing = sauce.LetterSpacing8Pixel
+	case 9:
+		opts.LetterSpacing = sauce.LetterSpacing9Pixel
+	default:
+		log.Fatalf("%s: unsupported letter spacing %d\n", filename, *letterSpacingFlag)
+	}
+	switch strings.ToLower(*aspectFlag) {
+	case "":
+	case "square":
+		opts.AspectRatio = sauce.AspectRatioSquare
+	case "legacy", "stretch":
+		opts.AspectRatio = sauce.AspectRatioStretch
+	default:
+		log.Fatalf("%s: unsupported aspect ratio %q\n", filename, *aspectFlag)
+	}
+	if *encodingFlag != "" {
+		if opts.Encoding, err = ianaindex.IANA.Encoding(*encodingFlag); err != nil || opts.Encoding == nil {
+			log.Fatalf("%s: unsupported encoding %q\n", filename, *encodingFlag)
+		}
+	}
+	if *fontFlag != "" {
+		if opts.Font, err = getFont(*fontFlag, *fontSizeFlag, *defaultFontSizeFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+	}
+	p := t.New(opts, s)
+	render := buffer.RenderOptions{Scale: *scaleFlag}
+	if *thumbnailFlag != "" {
+		if render.Thumbnail, err = font.ParseSize(*thumbnailFlag); err != nil {
+			log.Fatalf("%s: invalid thumbnail size %q\n", filename, *thumbnailFlag)
 		}
 	}
+	if *backgroundFlag != "" {
+		if render.Background, err = parseColor(*backgroundFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+	}
+	resampling := buffer.Nearest
+	if *smoothFlag {
+		resampling = buffer.Smooth
+	}
 
-	if p == nil {
-		log.Fatalf("%s: no suitable parser found\n", filename)
+	var enc animation.Encoder
+	if animate {
+		a, ok := p.(*ansi.ANSI)
+		if !ok {
+			log.Fatalf("%s: animation is only supported for ANSi\n", filename)
+		}
+		var pieceFont *font.Font
+		if pieceFont, err = parserFont(p, *fontFlag, *defaultFontFlag, *fontSizeFlag, *defaultFontSizeFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+		if render.Fonts, err = alternateFonts(pieceFont, *alternateFontsFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+		if enc, err = newEncoder(*formatFlag, *outputFlag, o, *fpsFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+		frame := func(f *ansi.Frame) error {
+			i, err := f.Buffer.Render(f.Palette, pieceFont, &render)
+			if err != nil {
+				return err
+			}
+			return enc.Encode(i, f.Time)
+		}
+		a.Buffer().Resampling = resampling
+		if *baudFlag > 0 {
+			a.Recorder = ansi.NewBaudRecorder(*baudFlag, *fpsFlag, frame)
+		} else {
+			a.Recorder = &ansi.Recorder{
+				Every: *everyFlag,
+				Delay: time.Second / time.Duration(*fpsFlag),
+				Frame: frame,
+			}
+		}
 	}
 
 	if _, err = r.Seek(0, 0); err != nil {
@@ -157,6 +361,17 @@ func main() {
 	if err = p.Parse(r); err != nil {
 		log.Fatalf("%s: parse failed: %v\n", filename, err)
 	}
+	p.Buffer().Resampling = resampling
+	for _, w := range p.Warnings() {
+		log.Printf("%s: %v\n", filename, &w)
+	}
+
+	if enc != nil {
+		if err = enc.Close(); err != nil {
+			log.Fatalf("%s: encode failed: %v\n", filename, err)
+		}
+		return
+	}
 
 	switch *formatFlag {
 	case "html":
@@ -167,30 +382,31 @@ func main() {
 		fmt.Fprint(o, html)
 
 	case "image", "gif", "jpg", "jpeg", "png":
-		var pieceFont = p.Font()
-		if pieceFont == nil {
-			if *fontFlag == "" {
-				*fontFlag = *defaultFontFlag
-			}
-			if *fontSizeFlag == "" {
-				*fontSizeFlag = *defaultFontSizeFlag
-			}
-
-			var fontSize image.Point
-			if fontSize, err = font.ParseSize(*fontSizeFlag); err != nil {
-				log.Fatalf("%s: %v\n", filename, err)
-			}
-			pieceFont = font.Get(*fontFlag, fontSize)
+		var pieceFont *font.Font
+		if pieceFont, err = parserFont(p, *fontFlag, *defaultFontFlag, *fontSizeFlag, *defaultFontSizeFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
+		}
+		if render.Fonts, err = alternateFonts(pieceFont, *alternateFontsFlag); err != nil {
+			log.Fatalf("%s: %v\n", filename, err)
 		}
 
 		var i image.Image
-		if i, err = p.Image(pieceFont); err != nil || i == nil {
-			log.Fatalln("%s: render failed: %v\n", filename, err)
+		if *historyFlag {
+			i, err = parserBuffer(p, true).Render(parserPalette(p), pieceFont, &render)
+		} else {
+			i, err = p.Render(pieceFont, &render)
+		}
+		if err != nil || i == nil {
+			log.Fatalf("%s: render failed: %v\n", filename, err)
 		}
 
 		switch *formatFlag {
 		case "gif":
-			err = gif.Encode(o, i, nil)
+			if b := parserBuffer(p, *historyFlag); *blinkFlag && b.HasBlink() {
+				err = encodeBlink(o, b, parserPalette(p), pieceFont, render, i)
+			} else {
+				err = gif.Encode(o, i, nil)
+			}
 
 		case "jpeg", "jpg":
 			err = jpeg.Encode(o, i, nil)
@@ -199,12 +415,33 @@ func main() {
 			err = png.Encode(o, i)
 		}
 		if err != nil {
-			log.Fatalln("%s: encode failed: %v\n", filename, err)
+			log.Fatalf("%s: encode failed: %v\n", filename, err)
+		}
+
+	case "irc":
+		e := irc.NewEncoder(o)
+		e.Extended = *ircExtendedFlag
+		if err = e.Encode(parserBuffer(p, *historyFlag), parserPalette(p)); err != nil {
+			log.Fatalf("%s: encode failed: %v\n", filename, err)
 		}
 
 	case "text":
 		fmt.Fprint(o, p.String())
 
+	case "midi":
+		var notes []music.Note
+		if p, ok := p.(*ansi.ANSI); ok {
+			for _, tune := range p.Music() {
+				notes = append(notes, tune.Notes...)
+			}
+		}
+		if len(notes) == 0 {
+			log.Fatalf("%s: no music found\n", filename)
+		}
+		if err = music.WriteMIDI(o, notes); err != nil {
+			log.Fatalf("%s: encode failed: %v\n", filename, err)
+		}
+
 	default:
 		log.Fatalf("Unknown format %q\n", *formatFlag)
 	}
@@ -8,7 +8,6 @@ import (
 	"image/color"
 	_ "image/png"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -158,10 +157,6 @@ func (c *Collection) LoadAll(path string) error {
 			return err
 		}
 		c.Add(name, size, font)
-		log.Printf("font: %s [%dx%d]\n", name, size.X, size.Y)
-		if fontAlias[name] != "" {
-			log.Printf("font: %s -> %s\n", name, fontAlias[name])
-		}
 	}
 
 	return nil
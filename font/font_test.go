@@ -8,3 +8,18 @@ func TestAll(t *testing.T) {
 	}
 	t.Logf("%d builtin fonts loaded", builtin.Len())
 }
+
+func TestNewBinary(t *testing.T) {
+	for _, h := range []int{0, -1} {
+		if _, err := NewBinary(make([]byte, 256), h); err == nil {
+			t.Errorf("height %d: expected an error", h)
+		}
+	}
+	f, err := NewBinary(make([]byte, 8*256), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size.X != 8 || f.Size.Y != 8 {
+		t.Errorf("expected an 8x8 font, got %s", f.Size)
+	}
+}
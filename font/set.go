@@ -0,0 +1,21 @@
+package font
+
+// Set is a primary font with alternate fonts, indexed by font number. Font 0
+// is the primary font, ANSi selects the alternates 1-9 with SGR 11-19.
+type Set []*Font
+
+// NewSet returns a font set with a primary font and alternates.
+func NewSet(primary *Font, alternate ...*Font) Set {
+	return append(Set{primary}, alternate...)
+}
+
+// Font returns font n, or the primary font if font n is not in the set.
+func (s Set) Font(n int) *Font {
+	if n > 0 && n < len(s) && s[n] != nil {
+		return s[n]
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	return s[0]
+}
@@ -0,0 +1,32 @@
+package font
+
+import (
+	"image"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	var (
+		f   = Get("cp437", image.Pt(8, 16))
+		alt = Get("cp866", image.Pt(8, 16))
+		s   = NewSet(f, nil, alt)
+	)
+	tests := []struct {
+		N    int
+		Want *Font
+	}{
+		{0, f},
+		{1, f},
+		{2, alt},
+		{3, f},
+		{-1, f},
+	}
+	for _, test := range tests {
+		if got := s.Font(test.N); got != test.Want {
+			t.Errorf("font %d: expected %v, got %v", test.N, test.Want, got)
+		}
+	}
+	if got := Set(nil).Font(1); got != nil {
+		t.Errorf("expected no font in an empty set, got %v", got)
+	}
+}
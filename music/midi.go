@@ -0,0 +1,97 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Division is the number of MIDI ticks per quarter note
+const Division = 480
+
+// Program is the General MIDI instrument of the tune, a square wave lead
+// sounds closest to the PC speaker.
+const Program = 80
+
+// MIDI events
+const (
+	midiNoteOff       = 0x80
+	midiNoteOn        = 0x90
+	midiProgramChange = 0xc0
+	midiMeta          = 0xff
+	midiMetaTempo     = 0x51
+	midiMetaEndTrack  = 0x2f
+	midiVelocity      = 0x64
+)
+
+// WriteMIDI writes the notes as a single track Standard MIDI File (format 0).
+func WriteMIDI(w io.Writer, notes []Note) error {
+	var (
+		track bytes.Buffer
+		delta uint32 // Ticks since the last event
+		tempo int
+	)
+	event := func(b ...byte) {
+		writeVarInt(&track, delta)
+		track.Write(b)
+		delta = 0
+	}
+
+	event(midiProgramChange, Program)
+	for _, n := range notes {
+		if n.Tempo != tempo && n.Tempo > 0 {
+			// Microseconds per quarter note
+			us := 60000000 / n.Tempo
+			event(midiMeta, midiMetaTempo, 3, byte(us>>16), byte(us>>8), byte(us))
+			tempo = n.Tempo
+		}
+
+		ticks := uint32(math.Round(n.Quarters() * Division))
+		on := uint32(math.Round(float64(ticks) * n.Sounding()))
+		if on == 0 || n.Pitch < 0 || n.Pitch > 127 {
+			delta += ticks
+			continue
+		}
+		event(midiNoteOn, byte(n.Pitch), midiVelocity)
+		delta = on
+		event(midiNoteOff, byte(n.Pitch), 0)
+		delta = ticks - on
+	}
+	event(midiMeta, midiMetaEndTrack, 0)
+
+	header := struct {
+		Format, Tracks, Division uint16
+	}{0, 1, Division}
+	for _, chunk := range []struct {
+		id   string
+		data interface{}
+		size int
+	}{
+		{"MThd", header, 6},
+		{"MTrk", track.Bytes(), track.Len()},
+	} {
+		if _, err := io.WriteString(w, chunk.id); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(chunk.size)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeVarInt writes a MIDI variable length quantity
+func writeVarInt(w *bytes.Buffer, v uint32) {
+	var b [5]byte
+	i := len(b) - 1
+	b[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		b[i] = byte(v&0x7f) | 0x80
+	}
+	w.Write(b[i:])
+}
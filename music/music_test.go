@@ -0,0 +1,66 @@
+package music
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPlay(t *testing.T) {
+	i := NewInterpreter()
+	notes, err := i.Play("MBT240L8O3CE-4.P16N0>c#ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Note{
+		{Pitch: 60, Octave: 3, Length: 8, Tempo: 240},
+		{Pitch: 63, Octave: 3, Length: 4, Dots: 1, Tempo: 240},
+		{Pitch: Rest, Octave: 3, Length: 16, Tempo: 240},
+		{Pitch: Rest, Octave: 3, Length: 8, Tempo: 240},
+		{Pitch: 73, Octave: 4, Length: 8, Tempo: 240},
+	}
+	if len(notes) != len(want) {
+		t.Fatalf("expected %d notes, got %+v", len(want), notes)
+	}
+	for j, n := range want {
+		if notes[j] != n {
+			t.Errorf("note %d: expected %+v, got %+v", j, n, notes[j])
+		}
+	}
+	if d := notes[1].Duration(); d != 375*time.Millisecond {
+		t.Errorf("expected dotted quarter note of 375ms, got %s", d)
+	}
+	if !i.Background || i.Style != Legato || i.Octave != 4 {
+		t.Errorf("expected state to carry over, got %+v", i)
+	}
+
+	if notes, err = i.Play("CDXE"); err == nil || len(notes) != 2 {
+		t.Errorf("expected error after 2 notes, got %v %+v", err, notes)
+	}
+}
+
+func TestWriteMIDI(t *testing.T) {
+	notes := []Note{
+		{Pitch: 60, Length: 4, Tempo: 120, Style: Legato},
+		{Pitch: Rest, Length: 4, Tempo: 120},
+		{Pitch: 62, Length: 2, Tempo: 120, Style: Staccato},
+	}
+	var b bytes.Buffer
+	if err := WriteMIDI(&b, notes); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0x01, 0xe0,
+		'M', 'T', 'r', 'k', 0, 0, 0, 34,
+		0x00, 0xc0, Program,
+		0x00, 0xff, 0x51, 3, 0x07, 0xa1, 0x20,
+		0x00, 0x90, 60, 0x64,
+		0x83, 0x60, 0x80, 60, 0,
+		0x83, 0x60, 0x90, 62, 0x64, // After the rest
+		0x85, 0x50, 0x80, 62, 0,
+		0x81, 0x70, 0xff, 0x2f, 0,
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("expected\n% x\ngot\n% x", want, b.Bytes())
+	}
+}
@@ -0,0 +1,283 @@
+// Package music interprets BASIC PLAY strings, as used by ANSi music, and
+// writes them as Standard MIDI Files.
+package music
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Rest is the pitch of a pause
+const Rest = -1
+
+// Default PLAY state
+const (
+	DefaultOctave = 4
+	DefaultLength = 4
+	DefaultTempo  = 120
+)
+
+// Style is the articulation of notes.
+type Style int
+
+// Articulation styles
+const (
+	Normal   Style = iota // Notes play 7/8 of their length
+	Legato                // Notes play their full length
+	Staccato              // Notes play 3/4 of their length
+)
+
+// Note is a note or pause in a tune.
+type Note struct {
+	// Pitch is the MIDI note number, or Rest. Middle C is in octave 3.
+	Pitch int
+
+	// Octave of the note, 0-6
+	Octave int
+
+	// Length of the note, 1 is a whole note and 4 is a quarter note
+	Length int
+
+	// Dots extend the note length by half, for every dot
+	Dots int
+
+	// Tempo in quarter notes per minute
+	Tempo int
+
+	// Style of articulation
+	Style Style
+}
+
+// Quarters returns the length of the note in quarter notes.
+func (n Note) Quarters() float64 {
+	return 4 / float64(n.Length) * math.Pow(1.5, float64(n.Dots))
+}
+
+// Duration returns the time until the next note starts.
+func (n Note) Duration() time.Duration {
+	return time.Duration(n.Quarters() * float64(time.Minute) / float64(n.Tempo))
+}
+
+// Sounding returns the fraction of the duration the note plays, the rest of
+// the duration is silent.
+func (n Note) Sounding() float64 {
+	switch {
+	case n.Pitch == Rest:
+		return 0
+	case n.Style == Legato:
+		return 1
+	case n.Style == Staccato:
+		return 0.75
+	default:
+		return 0.875
+	}
+}
+
+// Tune is a played string.
+type Tune struct {
+	// Offset of the tune in the input
+	Offset int64
+
+	// Source is the PLAY string
+	Source string
+
+	// Background music doesn't halt the program while it plays
+	Background bool
+
+	Notes []Note
+}
+
+// Interpreter plays BASIC PLAY strings, the octave, length, tempo and
+// style carry over to the next string.
+type Interpreter struct {
+	Octave     int
+	Length     int
+	Tempo      int
+	Style      Style
+	Background bool
+}
+
+// NewInterpreter returns an interpreter in the default state.
+func NewInterpreter() *Interpreter {
+	return &Interpreter{
+		Octave: DefaultOctave,
+		Length: DefaultLength,
+		Tempo:  DefaultTempo,
+	}
+}
+
+// semitones of the notes A-G above C
+var semitones = [...]int{9, 11, 0, 2, 4, 5, 7}
+
+// Play interprets a PLAY string. The notes played before a malformed
+// command are returned with the error.
+func (i *Interpreter) Play(s string) (notes []Note, err error) {
+	r := &reader{s: strings.ToUpper(s)}
+	for {
+		r.skip()
+		if r.eof() {
+			return
+		}
+		pos := r.pos
+		switch cmd := r.next(); {
+		case cmd >= 'A' && cmd <= 'G':
+			pitch := 24 + i.Octave*12 + semitones[cmd-'A']
+			switch r.peek() {
+			case '#', '+':
+				r.next()
+				pitch++
+			case '-':
+				r.next()
+				pitch--
+			}
+			n := i.note(pitch, i.Octave)
+			if v, ok := r.number(); ok {
+				if v < 1 || v > 64 {
+					return notes, syntaxError(s, pos, "invalid length %d", v)
+				}
+				n.Length = v
+			}
+			n.Dots = r.dots()
+			notes = append(notes, n)
+
+		case cmd == 'N':
+			v, ok := r.number()
+			if !ok || v > 84 {
+				return notes, syntaxError(s, pos, "invalid note number")
+			}
+			n := i.note(Rest, i.Octave)
+			if v > 0 {
+				n.Pitch = 23 + v
+				n.Octave = (v - 1) / 12
+			}
+			n.Dots = r.dots()
+			notes = append(notes, n)
+
+		case cmd == 'P':
+			v, ok := r.number()
+			if !ok || v < 1 || v > 64 {
+				return notes, syntaxError(s, pos, "invalid pause length")
+			}
+			n := i.note(Rest, i.Octave)
+			n.Length = v
+			n.Dots = r.dots()
+			notes = append(notes, n)
+
+		case cmd == 'O':
+			v, ok := r.number()
+			if !ok || v > 6 {
+				return notes, syntaxError(s, pos, "invalid octave")
+			}
+			i.Octave = v
+
+		case cmd == '<':
+			if i.Octave > 0 {
+				i.Octave--
+			}
+
+		case cmd == '>':
+			if i.Octave < 6 {
+				i.Octave++
+			}
+
+		case cmd == 'L':
+			v, ok := r.number()
+			if !ok || v < 1 || v > 64 {
+				return notes, syntaxError(s, pos, "invalid length")
+			}
+			i.Length = v
+
+		case cmd == 'T':
+			v, ok := r.number()
+			if !ok || v < 32 || v > 255 {
+				return notes, syntaxError(s, pos, "invalid tempo")
+			}
+			i.Tempo = v
+
+		case cmd == 'M':
+			switch r.next() {
+			case 'F':
+				i.Background = false
+			case 'B':
+				i.Background = true
+			case 'N':
+				i.Style = Normal
+			case 'L':
+				i.Style = Legato
+			case 'S':
+				i.Style = Staccato
+			default:
+				return notes, syntaxError(s, pos, "invalid music mode")
+			}
+
+		default:
+			return notes, syntaxError(s, pos, "unsupported command %q", cmd)
+		}
+	}
+}
+
+// note returns a note with the current length, tempo and style
+func (i *Interpreter) note(pitch, octave int) Note {
+	return Note{
+		Pitch:  pitch,
+		Octave: octave,
+		Length: i.Length,
+		Tempo:  i.Tempo,
+		Style:  i.Style,
+	}
+}
+
+func syntaxError(s string, pos int, format string, v ...interface{}) error {
+	return fmt.Errorf("music: %s at position %d in %q", fmt.Sprintf(format, v...), pos, s)
+}
+
+// reader scans a PLAY string
+type reader struct {
+	s   string
+	pos int
+}
+
+func (r *reader) eof() bool { return r.pos >= len(r.s) }
+
+func (r *reader) peek() byte {
+	if r.eof() {
+		return 0
+	}
+	return r.s[r.pos]
+}
+
+func (r *reader) next() byte {
+	c := r.peek()
+	r.pos++
+	return c
+}
+
+// skip white space and separators
+func (r *reader) skip() {
+	for !r.eof() && (r.s[r.pos] == ' ' || r.s[r.pos] == ';') {
+		r.pos++
+	}
+}
+
+// number reads a decimal number
+func (r *reader) number() (v int, ok bool) {
+	for c := r.peek(); c >= '0' && c <= '9'; c = r.peek() {
+		if v < 1000 {
+			v = v*10 + int(c-'0')
+		}
+		r.pos++
+		ok = true
+	}
+	return
+}
+
+// dots reads the dots after a note
+func (r *reader) dots() (n int) {
+	for r.peek() == '.' {
+		r.pos++
+		n++
+	}
+	return
+}
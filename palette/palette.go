@@ -91,33 +91,8 @@ func init() {
 	}
 }
 
+// IsBuiltin checks if p shares its colors with one of the builtin palettes, a
+// builtin palette must be copied before it is modified.
 func IsBuiltin(p Palette) bool {
-	return &p == &CGA || &p == &VGA
-}
-
-func init() {
-	// Initialize the VGA palette
-	for i := 0; i < 16; i++ {
-		VGA = append(VGA, CGA[i])
-	}
-
-	// Next add a 6x6x6 color cube
-	for r := uint8(0); r < 6; r++ {
-		for g := uint8(0); g < 6; g++ {
-			for b := uint8(0); b < 6; b++ {
-				VGA = append(VGA, color.RGBA{
-					0x37 + r*0x28,
-					0x37 + g*0x28,
-					0x37 + b*0x28,
-					0xff,
-				})
-			}
-		}
-	}
-
-	// And finally the gray scale ramp
-	for i := uint8(0); i < 24; i++ {
-		g := 10*i + 8
-		VGA = append(VGA, color.RGBA{g, g, g, 0xff})
-	}
+	return len(p) > 0 && (&p[0] == &CGA[0] || &p[0] == &VGA[0])
 }
@@ -2,22 +2,17 @@
 package ansi
 
 import (
-	"bufio"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
+	"context"
+	"errors"
+	"html"
 	"image"
 	"io"
-	"io/ioutil"
-	"log"
 	"strconv"
 	"strings"
 
 	"git.maze.io/maze/go-piece/buffer"
-	"git.maze.io/maze/go-piece/buffer/attribute"
 	"git.maze.io/maze/go-piece/font"
-	"git.maze.io/maze/go-piece/math"
+	"git.maze.io/maze/go-piece/music"
 	"git.maze.io/maze/go-piece/palette"
 	"git.maze.io/maze/go-piece/parser"
 	sauce "git.maze.io/maze/go-sauce"
@@ -26,15 +21,14 @@ import (
 	"golang.org/x/text/transform"
 )
 
-const (
-	tabStop = 8
-)
+// maxSAUCESize is the size of a SAUCE record with the maximum number of
+// comment lines
+const maxSAUCESize = 128 + 5 + 255*64
 
+// Default canvas size
 const (
-	stateExit = iota
-	stateText
-	stateANSIWaitBrace
-	stateANSIWaitLiteral
+	DefaultWidth  = 80
+	DefaultHeight = 25
 )
 
 // ECMA-48 specified Final Bytes of control sequences without intermediate bytes
@@ -87,10 +81,10 @@ const (
 	AnsiSGR                     // 'm', Select Graphic Rendition
 	AnsiDSR                     // 'n', Device Status Report
 	AnsiDAQ                     // 'o', Define Area Qualification
-	_                           // Unused
-	_                           // Unused
 	AnsiSKS                     // 'p', Set Keyboard String (ANSI.SYS)
-	AnsiSCP                     // 's', Save Cursor Position (ANSI.SYS)
+	_                           // Unused
+	AnsiDECSTBM                 // 'r', Set Top and Bottom Margins (DEC)
+	AnsiSCP                     // 's', Save Cursor Position (ANSI.SYS), with parameters Set Left and Right Margins (DEC)
 	AnsiXXX                     // 't', "24 bit ANSi" (PabloDraw only)
 	AnsiRCP                     // 'u', Restore Cursor Position (ANSI.SYS)
 )
@@ -99,44 +93,150 @@ type ansiOp func(seq *Sequence) error
 
 // ANSI or ASCII parser
 type ANSI struct {
-	Palette   palette.Palette
+	Palette palette.Palette
+
+	// Response receives the replies to terminal queries, such as the cursor
+	// position report. If nil, queries are ignored.
+	Response io.Writer
+
+	// Recorder takes snapshots of the buffer while the input is parsed
+	Recorder *Recorder
+
 	buffer    *buffer.Buffer
 	opcode    map[byte]ansiOp
 	transform transform.Transformer
+	options   parser.Options
 	save      *buffer.Cursor
 	sauce     *sauce.SAUCE
+	tabs      []bool
+	warnings  parser.WarningLog
+
+	// Scroll region and scrollback buffer, in screen mode
+	margin     image.Rectangle
+	scrollback []*buffer.Tile
+	wrap       bool // Line wrap pending
+	wrapY      int
+
+	// ANSi music
+	music  *musicCapture
+	player *music.Interpreter
+	tunes  []music.Tune
+
+	// Last graphic character, for REP
+	last    byte
+	hasLast bool
+
+	// Terminal state for escape sequences and control strings
+	initial  palette.Palette
+	graphics bool // DEC special graphics
+	saved    *savedCursor
+	title    string
+
+	// Stream state, kept between writes
+	input     io.WriteCloser
+	tokenizer *Tokenizer
+	token     *Token // Token being handled
+	eof       bool   // After the end of file marker
+	offset    int64
+	trailer   []byte
 }
 
-// New initializes a new ANSi parser with an initial given width and height
-func New(w, h int) *ANSI {
+// New initializes a new ANSi parser, the options may be nil. The initial
+// canvas size defaults to 80x25.
+func New(o *parser.Options) *ANSI {
+	opts := o.Copy()
+	if opts.Width <= 0 {
+		opts.Width = DefaultWidth
+	}
+	if opts.Height <= 0 {
+		opts.Height = DefaultHeight
+	}
+
 	p := &ANSI{
 		Palette:   palette.CGA,
-		buffer:    buffer.New(w, h),
+		buffer:    buffer.New(opts.Width, opts.Height),
 		transform: charmap.CodePage437.NewDecoder(),
+		options:   opts,
 	}
+	p.warnings.Strict = opts.Strict
+	if opts.Palette != nil {
+		p.Palette = opts.Palette.Copy()
+	}
+	p.initial = p.Palette
+	p.buffer.Flags = opts.Flags(p.buffer.Flags)
+	p.buffer.Limits = opts.Limits
 	p.opcode = map[byte]ansiOp{
+		AnsiCBT: p.parseCBT,
 		AnsiCHA: p.parseCHA,
+		AnsiCHT: p.parseCHT,
 		AnsiCNL: p.parseCNL,
-		AnsiCPL: p.parseCHA,
+		AnsiCPL: p.parseCPL,
+		AnsiCTC: p.parseCTC,
 		AnsiCUB: p.parseCUB,
 		AnsiCUD: p.parseCUD,
 		AnsiCUF: p.parseCUF,
 		AnsiCUP: p.parseCUP,
 		AnsiCUU: p.parseCUU,
+		AnsiDA:  p.parseDA,
+		AnsiDCH: p.parseDCH,
+		AnsiDL:  p.parseDL,
+		AnsiDSR: p.parseDSR,
+		AnsiECH: p.parseECH,
 		AnsiED:  p.parseED,
 		AnsiEL:  p.parseEL,
+		AnsiHPA: p.parseCHA, // alias
+		AnsiHPB: p.parseCUB, // alias
+		AnsiHPR: p.parseCUF, // alias
+		AnsiICH: p.parseICH,
 		AnsiIL:  p.parseIL,
 		AnsiHVP: p.parseCUP, // alias
+		AnsiREP: p.parseREP,
+		AnsiTBC: p.parseTBC,
+		AnsiVPA: p.parseVPA,
+		AnsiVPB: p.parseCUU, // alias
+		AnsiVPR: p.parseCUD, // alias
 		AnsiRM:  p.parseRM,
+		AnsiSD:  p.parseSD,
+		AnsiSU:  p.parseSU,
 		AnsiSGR: p.parseSGR,
 		AnsiSM:  p.parseSM,
 		AnsiSCP: p.parseSCP,
 		AnsiRCP: p.parseRCP,
 		AnsiXXX: p.parseXXX,
+
+		AnsiDECSTBM: p.parseDECSTBM,
+	}
+	if opts.Screen {
+		// The whole screen is visible
+		p.buffer.SizeMaxToSize()
 	}
+	p.resetMargins()
+	p.resetTabs()
+	p.Reset()
 	return p
 }
 
+func init() {
+	parser.Register(&parser.Format{
+		Name:       "ANSi/ASCII",
+		Names:      []string{"ansi", "ascii", "text"},
+		Extensions: []string{".asc", ".ans", ".txt", ".diz", ".lit"},
+		SAUCE: []parser.SAUCEType{
+			{DataType: sauce.DataTypeCharacter, FileType: 0}, // ASCII
+			{DataType: sauce.DataTypeCharacter, FileType: 1}, // ANSi
+		},
+		Score: score,
+		New: func(o *parser.Options, s *sauce.SAUCE) parser.Parser {
+			opts := o.Copy()
+			if opts.Width == 0 && s != nil && s.TInfo[0] > 0 {
+				// Character width
+				opts.Width = int(s.TInfo[0])
+			}
+			return New(&opts)
+		},
+	})
+}
+
 // ForceSize sets the buffer max size to the desired dimensions
 func (p *ANSI) ForceSize() *ANSI {
 	p.buffer.SizeMaxToSize()
@@ -144,203 +244,212 @@ func (p *ANSI) ForceSize() *ANSI {
 }
 
 // Parse the ANSi sequences from a reader
-func (p *ANSI) Parse(r io.Reader) (err error) {
-	state := stateText
-	buf := bufio.NewReader(r)
-
-	var seq = NewSequence()
-	var n, t int
-	for state != stateExit {
-		var ch byte
-		if ch, err = buf.ReadByte(); err != nil {
-			// EOF is to be expected
-			if err == io.EOF {
-				err = nil
-			}
-			state = stateExit
-			continue
-		}
-
-		t += n
-
-		switch state {
-		case stateText:
-			switch ch {
-			case SUB: // End Of File
-				state = stateExit
-
-				// Parse remainder to check the SAUCE record
-				if b, errs := ioutil.ReadAll(buf); errs == nil || errs == io.EOF {
-					if p.sauce, errs = sauce.ParseBytes(b); errs != nil {
-						log.Printf("ansi: %v\n", errs)
-					}
-				}
-
-			case ESC:
-				state = stateANSIWaitBrace
-
-			case NL:
-				p.buffer.Cursor.Y++
-				p.buffer.Cursor.X = 0
-
-			case CR:
-				p.buffer.Cursor.X = 0
-
-			case TAB:
-				c := (p.buffer.Cursor.X + 1) % tabStop
-				if c > 0 {
-					c = tabStop - c
-					for i := 0; i < c; i++ {
-						p.buffer.PutChar(' ')
-					}
-				}
-			default:
-				p.buffer.PutChar(ch)
-			}
+func (p *ANSI) Parse(r io.Reader) error {
+	return p.ParseContext(context.Background(), r)
+}
 
-		case stateANSIWaitBrace:
-			if ch == '[' {
-				state = stateANSIWaitLiteral
-			} else {
-				p.buffer.PutChar(ESC)
-				p.buffer.PutChar(ch)
-			}
+// ParseContext parses the ANSi sequences from a reader, until the context is
+// done. The parser state is reset before parsing.
+func (p *ANSI) ParseContext(ctx context.Context, r io.Reader) (err error) {
+	p.Reset()
+	if _, err = io.Copy(p, parser.ContextReader(ctx, r)); err != nil {
+		return
+	}
+	return p.Close()
+}
 
-		case stateANSIWaitLiteral:
-			if ch == ';' {
-				seq.Flush()
-				break
-			}
+// Reset the parser state, so a new stream can be written. The buffer is kept.
+func (p *ANSI) Reset() {
+	p.tokenizer = NewTokenizer(nil)
+	p.token = nil
+	p.eof = false
+	p.music = nil
+	p.player = music.NewInterpreter()
+	p.tunes = nil
+	if p.Recorder != nil {
+		p.Recorder.reset(0)
+	}
+	p.offset = 0
+	p.trailer = nil
+	p.hasLast = false
+	p.input = p.options.Writer((*rawWriter)(p))
+	p.warnings.ResetWarnings()
+}
 
-			if isAlpha(ch) {
-				seq.Flush()
-				//log.Printf("ANSI sequence <ESC>[%s%c (0x%02x)\n", seq, ch, ch)
-
-				fn := p.opcode[ch]
-				if fn == nil {
-					log.Printf("Unsupported ANSI sequence <ESC>[%s%c (0x%02x)\n", seq, ch, ch)
-				} else {
-					if err = fn(seq); err != nil {
-						log.Printf("Parser error: %v\n", err)
-					}
-				}
-
-				seq.Reset()
-				state = stateText
-				break
-			} // if isAlpha(ch)
-			seq.Buffer(ch)
+// Write parses the ANSi sequences in b. The parser state is kept between
+// writes, so the input can be written in arbitrary chunks. The buffer can be
+// inspected between writes.
+func (p *ANSI) Write(b []byte) (int, error) {
+	return p.input.Write(b)
+}
 
-		default:
-			break
+// Close ends the input written to the parser and imports the SAUCE record,
+// if one was found after the end of file marker.
+func (p *ANSI) Close() (err error) {
+	if err = p.input.Close(); err != nil {
+		return
+	}
+	if !p.eof {
+		// Incomplete sequence or music string at the end of the input
+		if err = p.tokenizer.Flush(p.handle); err == nil && p.music != nil {
+			err = p.replayMusic(nil)
+		}
+	} else {
+		var errs error
+		if p.sauce, errs = sauce.ParseBytes(p.trailer); errs == nil {
+			p.applySAUCE()
+		} else if errs != sauce.ErrNoRecord {
+			err = p.warnings.Warn(parser.WarningSAUCE, p.offset-int64(len(p.trailer)), "", "%v", errs)
 		}
 	}
-
+	if err == nil && p.Recorder != nil {
+		// The final state
+		err = p.snapshot()
+	}
 	return
 }
 
-// SetFlags imports SAUCE flags.
-func (p *ANSI) SetFlags(f sauce.TFlags) {
-	p.buffer.Flags = f
-}
+// rawWriter writes code page 437 input to the parser
+type rawWriter ANSI
 
-// HTML returns the internal buffer as HTML.
-func (p *ANSI) HTML(full bool) (s string, err error) {
-	if full {
-		s += "<!doctype html>\n"
-		s += "<link rel=\"stylesheet\" href=\"cp437.css\">\n"
+func (w *rawWriter) Write(b []byte) (n int, err error) {
+	p := (*ANSI)(w)
+	if p.offset == 0 {
+		if err = p.options.Limits.Check(p.buffer.Width, p.buffer.Height); err != nil {
+			return
+		}
 	}
-	a := randomPrefix(3)
-	s += "<style type=\"text/css\">\n"
-	for i := 0; i < len(p.Palette); i++ {
-		r, g, b, _ := p.Palette[i].RGBA()
-		c := fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
-		s += fmt.Sprintf(".f%s%02x{color:%s} ", a, i, c)
-		s += fmt.Sprintf(".b%s%02x{background-color:%s} ", a, i, c)
-		s += fmt.Sprintf(".u%s%02x{border-bottom:1px solid %s}", a, i, c)
-		s += "\n"
+	if !p.eof {
+		n, err = p.feed(b)
+		if err != errEOF {
+			return
+		}
+		err = nil
 	}
-	s += `.i{font-variant:italics} .u{border-bottom:1px} .ud{border-bottom:3px dashed #000}`
-	s += "</style>"
-	if full {
-		s += `<pre>`
+
+	// Only keep what can hold a SAUCE record and its comments
+	p.trailer = append(p.trailer, b[n:]...)
+	if len(p.trailer) >= 2*maxSAUCESize {
+		p.trailer = append(p.trailer[:0], p.trailer[len(p.trailer)-maxSAUCESize:]...)
 	}
-	s += fmt.Sprintf(`<span class="b%s%02x f%s%02x">`,
-		a, buffer.DefaultBackground,
-		a, buffer.DefaultColor)
+	p.offset += int64(len(b) - n)
+	return len(b), nil
+}
 
-	w, h := p.buffer.SizeMax()
-	var l *buffer.Tile
+// errEOF stops the tokenizer at the end of file marker
+var errEOF = errors.New("ansi: end of file")
 
-	for o, t := range p.buffer.Tiles {
-		y, x := math.DivMod(o, p.buffer.Width)
-		if x >= w {
-			continue
-		}
-		if y >= h {
-			break
-		}
-		if x == 0 && y > 0 {
-			s += "\n"
-		}
-		if t == nil {
-			s += " "
-		} else if t.Equal(l) {
-			//s += string(t.Char)
-			if isPrint(t.Char) {
-				s += string(t.Char)
-			} else {
-				s += fmt.Sprintf(`&#x%02x;`, t.Char)
-			}
-		} else {
-			f := t.Color
-			b := t.Background
-			c := []string{}
+// handle a token from the tokenizer
+func (p *ANSI) handle(t *Token) (err error) {
+	if p.music != nil {
+		return p.captureMusic(t)
+	}
+	p.token = t
 
-			if t.Attributes&attribute.Bold == attribute.Bold {
-				f += 8
-			}
-			if t.Attributes&attribute.Blink == attribute.Blink {
-				b += 8
+	switch t.Kind {
+	case TokenText:
+		for _, ch := range t.Data {
+			if p.graphics && ch >= 0x5f && ch <= 0x7e {
+				ch = decGraphics[ch-0x5f]
 			}
-			if t.Attributes&attribute.Negative == attribute.Negative {
-				f, b = b, f
-			}
-			c = append(c, fmt.Sprintf("b%s%02x", a, b))
-			c = append(c, fmt.Sprintf("f%s%02x", a, f))
-			if t.Attributes&attribute.Italics > 0 {
-				c = append(c, "i")
-			}
-			if t.Attributes&attribute.Underline > 0 {
-				c = append(c, fmt.Sprintf("u%02x", f))
-			}
-			if t.Attributes&attribute.DoubleUnderline > 0 {
-				c = append(c, "ud")
+			if err = p.putChar(ch); err != nil {
+				return
 			}
+			p.last, p.hasLast = ch, true
+		}
 
-			s += `</span>`
-			s += fmt.Sprintf(`<span class="%s">`, strings.Join(c, " "))
-			if isPrint(t.Char) {
-				s += string(t.Char)
-			} else {
-				s += fmt.Sprintf(`&#x%02x;`, t.Char)
-			}
+	case TokenControl:
+		switch t.Final {
+		case SUB: // End Of File
+			p.eof = true
+			return errEOF
+		case NL:
+			p.lineFeed()
+		case CR:
+			p.buffer.Cursor.X = 0
+		case TAB:
+			p.buffer.Cursor.X = p.nextTab(p.buffer.Cursor.X)
+		default:
+			// Other control characters are glyphs in code page 437
+			err = p.putChar(t.Final)
+			p.last, p.hasLast = t.Final, true
 		}
 
-		l = t
-	}
+	case TokenCSI:
+		if !p.startMusic(t) {
+			err = p.controlSequence(t)
+		}
+
+	case TokenESC:
+		err = p.escape(t.Intermediate, t.Final)
 
-	s += `</span>`
-	if full {
-		s += `</pre>`
+	case TokenOSC:
+		err = p.controlString(t.Data)
+
+	case TokenInvalid:
+		err = p.warn(parser.WarningMalformed, "malformed sequence")
 	}
+	return
+}
 
+// controlSequence runs the function of a control sequence
+func (p *ANSI) controlSequence(t *Token) (err error) {
+	if fn := p.opcode[t.Final]; fn == nil || len(t.Intermediate) > 0 {
+		err = p.warn(parser.WarningUnsupported, "unsupported control sequence")
+	} else {
+		err = fn(newSequence(t))
+	}
+	p.clampCursor()
 	return
 }
 
-// Font returns nil, as an ANSi file has no font data.
+// warn records a warning for the control sequence being parsed, the
+// warning is returned in strict mode.
+func (p *ANSI) warn(kind parser.WarningKind, format string, v ...interface{}) error {
+	var (
+		offset int64
+		text   string
+	)
+	if p.token != nil {
+		offset, text = p.token.Offset, string(p.token.Raw)
+	}
+	return p.warnings.Warn(kind, offset, text, format, v...)
+}
+
+// applySAUCE imports the SAUCE flags, the options take precedence and modes
+// set by the piece are retained.
+func (p *ANSI) applySAUCE() {
+	f := p.options.Flags(p.sauce.TFlags)
+	f.NonBlink = f.NonBlink || p.buffer.Flags.NonBlink
+	p.buffer.Flags = f
+}
+
+// SetFlags imports SAUCE flags.
+func (p *ANSI) SetFlags(f sauce.TFlags) {
+	p.buffer.Flags = f
+}
+
+// HTML returns the internal buffer as HTML.
+func (p *ANSI) HTML(full bool) (string, error) {
+	head := "<link rel=\"stylesheet\" href=\"cp437.css\">\n"
+	if p.title != "" {
+		head += "<title>" + html.EscapeString(p.title) + "</title>\n"
+	}
+	return p.buffer.HTML(p.Palette, full, &buffer.HTMLOptions{
+		Head:       head,
+		Color:      buffer.DefaultColor,
+		Background: buffer.DefaultBackground,
+	}), nil
+}
+
+// Buffer returns the internal buffer.
+func (p *ANSI) Buffer() *buffer.Buffer {
+	return p.buffer
+}
+
+// Font returns the font from the options, as an ANSi file has no font data.
 func (p *ANSI) Font() *font.Font {
-	return nil
+	return p.options.Font
 }
 
 // Image returns the internal buffer as an image.
@@ -348,6 +457,11 @@ func (p *ANSI) Image(f *font.Font) (image.Image, error) {
 	return p.buffer.Image(p.Palette, f)
 }
 
+// Render returns the internal buffer as an image, with render options o.
+func (p *ANSI) Render(f *font.Font, o *buffer.RenderOptions) (image.Image, error) {
+	return p.buffer.Render(p.Palette, f, o)
+}
+
 func (p *ANSI) String() (s string) {
 	w, h := p.buffer.SizeMax()
 	for y := 0; y < h; y++ {
@@ -369,7 +483,13 @@ func (p *ANSI) Width() int          { return p.buffer.Width }
 func (p *ANSI) Height() int         { return p.buffer.Height }
 func (p *ANSI) SAUCE() *sauce.SAUCE { return p.sauce }
 
-var _ parser.Parser = (*ANSI)(nil)
+// Warnings returns the problems found during the last Parse.
+func (p *ANSI) Warnings() []parser.Warning { return p.warnings.Warnings() }
+
+var (
+	_ parser.Parser  = (*ANSI)(nil)
+	_ io.WriteCloser = (*ANSI)(nil)
+)
 
 // Sequence holds an ANSi escape sequence.
 type Sequence struct {
@@ -377,6 +497,34 @@ type Sequence struct {
 	b []byte
 }
 
+// newSequence returns the parameters of a control sequence token, the
+// private marker is kept in front of the first parameter.
+func newSequence(t *Token) *Sequence {
+	s := NewSequence()
+	var b []byte
+	if t.Private != 0 {
+		b = append(b, t.Private)
+	}
+	for i, param := range t.Params {
+		if i > 0 {
+			b = b[:0]
+		}
+		for j, v := range param {
+			if j > 0 {
+				b = append(b, ':')
+			}
+			if v >= 0 {
+				b = strconv.AppendInt(b, int64(v), 10)
+			}
+		}
+		s.s = append(s.s, string(b))
+	}
+	if len(s.s) == 0 {
+		s.s = append(s.s, string(b))
+	}
+	return s
+}
+
 // NewSequence initializes a new Sequence structure.
 func NewSequence() *Sequence {
 	return &Sequence{
@@ -425,6 +573,22 @@ func (s *Sequence) Len() int {
 	return len(s.s)
 }
 
+// Param returns item n with its colon separated sub-parameters, omitted
+// values are -1. The private marker is ignored.
+func (s *Sequence) Param(n int) (p Param) {
+	if n >= s.Len() {
+		return nil
+	}
+	for _, v := range strings.Split(strings.TrimLeft(s.s[n], "<=>?"), ":") {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			i = -1
+		}
+		p = append(p, i)
+	}
+	return
+}
+
 // Reset reinitializes the internal buffers
 func (s *Sequence) Reset() {
 	s.s = make([]string, 0)
@@ -445,10 +609,3 @@ func (s *Sequence) StringAt(n int) (out string) {
 	}
 	return
 }
-
-func randomPrefix(size int) string {
-	var buf = make([]byte, size)
-	io.ReadFull(rand.Reader, buf)
-	var sum = sha256.New().Sum(buf)
-	return hex.EncodeToString(sum)[:size]
-}
@@ -0,0 +1,552 @@
+package ansi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+	"git.maze.io/maze/go-piece/parser"
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+func TestWarnings(t *testing.T) {
+	const input = "ab\x1b[1;31mc\x1b[5zd\x1b[38;2;1m"
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	want := []parser.Warning{
+		{Kind: parser.WarningUnsupported, Offset: 10, Sequence: "\x1b[5z"},
+		{Kind: parser.WarningMalformed, Offset: 15, Sequence: "\x1b[38;2;1m"},
+	}
+	got := p.Warnings()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d warnings, got %v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Kind != w.Kind || got[i].Offset != w.Offset || got[i].Sequence != w.Sequence {
+			t.Errorf("warning %d: expected %s at %d %q, got %v", i, w.Kind, w.Offset, w.Sequence, &got[i])
+		}
+	}
+	if s := p.String(); !strings.HasPrefix(s, "abcd") {
+		t.Errorf("expected text to be parsed, got %q", s)
+	}
+
+	p = New(&parser.Options{Strict: true})
+	err := p.Parse(strings.NewReader(input))
+	var w *parser.Warning
+	if !errors.As(err, &w) {
+		t.Fatalf("expected a warning error, got %v", err)
+	}
+	if w.Offset != 10 {
+		t.Errorf("expected the first warning, got %v", w)
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		input string
+		w, h  int
+	}{
+		{"abc", 3, 1},
+		{"abc\r\n", 3, 1},
+		{"a\r\nbc", 2, 2},
+		{"\x1b[2;5Hx", 5, 2},
+		{strings.Repeat("x", 81), 80, 2},
+	}
+	for _, test := range tests {
+		p := New(nil)
+		if err := p.Parse(strings.NewReader(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		if w, h := p.Buffer().SizeMax(); w != test.w || h != test.h {
+			t.Errorf("%q: expected %dx%d, got %dx%d", test.input, test.w, test.h, w, h)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input string
+		err   bool
+	}{
+		{"hello\r\nworld", false},
+		{"\x1b[99999999Bx", true},
+		{"\x1b[9999L", true},
+		{"\x1b[38;2;1;2;3m\x1b[38;2;1;2;3mx", false},
+		{"\x1b[38;2;1;2;3m\x1b[38;2;4;5;6mx", true},
+		{"a\x1b[99999999999b", true},
+	}
+	for _, test := range tests {
+		p := New(&parser.Options{
+			Limits: buffer.Limits{Height: 100, Tiles: 8000, Colors: 17},
+		})
+		err := p.Parse(strings.NewReader(test.input))
+		if test.err && !errors.Is(err, parser.ErrLimitExceeded) {
+			t.Errorf("%q: expected limit error, got %v", test.input, err)
+		} else if !test.err && err != nil {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+		}
+	}
+
+	// The default limits apply without options, a width limit applies to
+	// the cursor column
+	for _, o := range []*parser.Options{nil, {Limits: buffer.Limits{Width: 100}}} {
+		p := New(o)
+		const input = "\x1b[99999999999999999999999B\x1b[99999999999999999999999Cx"
+		if err := p.Parse(strings.NewReader(input)); !errors.Is(err, parser.ErrLimitExceeded) {
+			t.Errorf("%+v: expected limit error, got %v", o, err)
+		}
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(nil).ParseContext(ctx, strings.NewReader("hello")); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	const input = "\x1b[1;31mhello\x1b[0m\r\n\x1b[2Cworld\x1b[38;2;1;2;3m!"
+
+	want := New(nil)
+	if err := want.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split sequences at every possible boundary
+	for size := 1; size < 8; size++ {
+		p := New(nil)
+		for i := 0; i < len(input); i += size {
+			j := i + size
+			if j > len(input) {
+				j = len(input)
+			}
+			if _, err := p.Write([]byte(input[i:j])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.String(); got != want.String() {
+			t.Errorf("chunks of %d: expected %q, got %q", size, want.String(), got)
+		}
+		if p.buffer.Cursor.Color != want.buffer.Cursor.Color {
+			t.Errorf("chunks of %d: expected color %d, got %d", size, want.buffer.Cursor.Color, p.buffer.Cursor.Color)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	var out strings.Builder
+	p := New(nil)
+	p.Response = &out
+	if err := p.Parse(strings.NewReader("\x1b[3;5Hab\x1b[6n\x1b[c\x1b[5n\x1b[?6n")); err != nil {
+		t.Fatal(err)
+	}
+	const want = "\x1b[3;7R\x1b[?1;2c\x1b[0n\x1b[?3;7;1R"
+	if got := out.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestEditing(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"abcdef\x1b[1G\x1b[2@", "  abcdef"},
+		{"abcdef\x1b[2G\x1b[2P", "adef"},
+		{"abcdef\x1b[2G\x1b[3X", "a   ef"},
+		{"abcdef\x1b[3G\x1b[K", "ab"},
+		{"abcdef\x1b[3G\x1b[1K", "   def"},
+		{"abcdef\x1b[3G\x1b[2K", ""},
+		{"a\x1b[3b", "aaaa"},
+		{"a\x1b[99Cb", "a        b"},
+		{"ab\x1b[99ac", "ab       c"},
+		{"a\tb", "a       b"},
+		{"\x1b[2Ia", "         a"},
+		{"abcdefghi\x1b[Zx", "abcdefghx"},
+		{"\x1b[4G\x1b[W\x1b[1G\tx", "   x"},
+		{"\x1b[3g\tx", "         x"},
+		{"a\r\nb\r\nc\x1b[1;1H\x1b[M", "b|c|"},
+		{"\x1b[3dx", "||x"},
+		{"a\r\nb\x1b[Fc", "c|b"},
+		{"ab\x1b[4`c\x1b[2jd", "abdc"},
+	}
+	for _, test := range tests {
+		p := New(&parser.Options{Width: 10})
+		if err := p.Parse(strings.NewReader(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(p.String(), "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " ")
+		}
+		if got := strings.Join(lines, "|"); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.input, test.want, got)
+		}
+	}
+}
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		input, screen, history string
+	}{
+		{"a\nb\nc\nd", "b|c|d", "a|b|c|d"},
+		{"abcdefghijkl", "abcd|efgh|ijkl", "abcd|efgh|ijkl"},
+		{"abcdefghijklm", "efgh|ijkl|m", "abcd|efgh|ijkl|m"},
+		{"a\nb\nc\x1b[S", "b|c|", "a|b|c|"},
+		{"a\nb\nc\x1b[T", "|a|b", "|a|b"},
+		{"a\nb\nc\x1b[2;3r\x1b[3;1H\nd", "a|c|d", "a|c|d"},
+		{"a\nb\nc\x1b[2;1H\x1b[M", "a|c|", "a|c|"},
+		{"a\nb\nc\x1b[1;1H\x1b[L", "|a|b", "|a|b"},
+		{"a\x1b[9B\x1b[99Cb", "a||   b", "a||   b"},
+		{"a\x1b[99999999999b", "aaaa|aaaa|a", "aaaa|aaaa|aaaa|a"},
+	}
+	for _, test := range tests {
+		p := New(&parser.Options{Width: 4, Height: 3, Screen: true})
+		if err := p.Parse(strings.NewReader(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		if got := bufferString(p.Buffer()); got != test.screen {
+			t.Errorf("%q: expected screen %q, got %q", test.input, test.screen, got)
+		}
+		if got := bufferString(p.History()); got != test.history {
+			t.Errorf("%q: expected history %q, got %q", test.input, test.history, got)
+		}
+	}
+}
+
+// bufferString returns the rows of the buffer, separated by a pipe
+func bufferString(b *buffer.Buffer) string {
+	w, h := b.SizeMax()
+	lines := make([]string, h)
+	for y := range lines {
+		var line []byte
+		for x := 0; x < w; x++ {
+			if t := b.Tiles[y*b.Width+x]; t == nil {
+				line = append(line, ' ')
+			} else {
+				line = append(line, t.Char)
+			}
+		}
+		lines[y] = strings.TrimRight(string(line), " ")
+	}
+	return strings.Join(lines, "|")
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		input, want, title string
+	}{
+		{"ab\x1b7\x1b[1;31mcd\x1b8e", "abed", ""},
+		{"\x1b(0lqk\x1b(Bq", "\xda\xc4\xbfq", ""},
+		{"\x1b]0;Hello\x07ab", "ab", "Hello"},
+		{"\x1b]2;Hello;World\x1b\\ab", "ab", "Hello;World"},
+		{"\x1bP1$r0m\x1b\\ab", "ab", ""},
+		{"\x1b]2;Cancelled\x1b[1Cab", " ab", "Cancelled"},
+		{"a\x1bc", "", ""},
+		{"a\x1bDb", "a| b", ""},
+		{"a\x1bEb", "a|b", ""},
+		{"a\x1bMb", " b|a", ""},
+		{"a\x1b#8b", "ab", ""},
+	}
+	for _, test := range tests {
+		p := New(&parser.Options{Width: 10})
+		if err := p.Parse(strings.NewReader(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		if got := bufferString(p.Buffer()); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.input, test.want, got)
+		}
+		if got := p.Title(); got != test.title {
+			t.Errorf("%q: expected title %q, got %q", test.input, test.title, got)
+		}
+	}
+}
+
+func TestOSCPalette(t *testing.T) {
+	var out strings.Builder
+	p := New(nil)
+	p.Response = &out
+	if err := p.Parse(strings.NewReader("\x1b]4;1;rgb:ff/80/00;2;#0000ff\x07\x1b]4;1;?\x07")); err != nil {
+		t.Fatal(err)
+	}
+	if c := p.Palette[1]; c != (color.RGBA{0xff, 0x80, 0x00, 0xff}) {
+		t.Errorf("expected color 1 to be changed, got %v", c)
+	}
+	if c := p.Palette[2]; c != (color.RGBA{0x00, 0x00, 0xff, 0xff}) {
+		t.Errorf("expected color 2 to be changed, got %v", c)
+	}
+	if c := palette.CGA[1]; c != (color.RGBA{0xaa, 0x00, 0x00, 0xff}) {
+		t.Errorf("builtin palette was modified")
+	}
+	if want := "\x1b]4;1;rgb:ffff/8080/0000\x1b\\"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+
+	if err := p.Parse(strings.NewReader("\x1b]104;1\x07")); err != nil {
+		t.Fatal(err)
+	}
+	if p.Palette[1] != palette.CGA[1] || p.Palette[2] == palette.CGA[2] {
+		t.Errorf("expected only color 1 to be reset")
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	const input = "ab\r\n\x1b[?25h\x1b[38:2::1:2:3;;5m\x1b]0;hi\x07\x1bPq#0\x1b\\\x1b(0\x1b7c\x1b[1\x82d\x1b[2"
+
+	type token struct {
+		kind   TokenKind
+		offset int64
+		raw    string
+	}
+	want := []token{
+		{TokenText, 0, "ab"},
+		{TokenControl, 2, "\r"},
+		{TokenControl, 3, "\n"},
+		{TokenCSI, 4, "\x1b[?25h"},
+		{TokenCSI, 10, "\x1b[38:2::1:2:3;;5m"},
+		{TokenOSC, 27, "\x1b]0;hi\x07"},
+		{TokenDCS, 34, "\x1bPq#0\x1b\\"},
+		{TokenESC, 41, "\x1b(0"},
+		{TokenESC, 44, "\x1b7"},
+		{TokenText, 46, "c"},
+		{TokenInvalid, 47, "\x1b[1"},
+		{TokenText, 50, "\x82d"},
+		{TokenInvalid, 52, "\x1b[2"},
+	}
+
+	z := NewTokenizer(strings.NewReader(input))
+	var got []*Token
+	for {
+		tok, err := z.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d tokens, got %v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Kind != w.kind || got[i].Offset != w.offset || string(got[i].Raw) != w.raw {
+			t.Errorf("token %d: expected %s %q at offset %d, got %v", i, w.kind, w.raw, w.offset, got[i])
+		}
+	}
+
+	if tok := got[3]; tok.Private != '?' || tok.Param(0, 0) != 25 || tok.Final != 'h' {
+		t.Errorf("expected private mode 25, got %+v", tok)
+	}
+	sgr := got[4].Params
+	if len(sgr) != 3 || len(sgr[0]) != 6 || sgr[0][2] != -1 || sgr[0][5] != 3 || sgr[1].Value(0) != 0 || sgr[2].Value(0) != 5 {
+		t.Errorf("unexpected parameters %v", sgr)
+	}
+	if s := string(got[5].Data); s != "0;hi" {
+		t.Errorf("expected OSC data %q, got %q", "0;hi", s)
+	}
+
+	// Byte by byte input tokenizes the same sequences
+	var (
+		fed []*Token
+		z2  = NewTokenizer(nil)
+	)
+	for i := 0; i < len(input); i++ {
+		z2.Feed([]byte{input[i]}, func(tok *Token) error {
+			if tok.Kind != TokenText {
+				fed = append(fed, tok)
+			}
+			return nil
+		})
+	}
+	z2.Flush(func(tok *Token) error {
+		fed = append(fed, tok)
+		return nil
+	})
+	var i int
+	for _, tok := range got {
+		if tok.Kind == TokenText {
+			continue
+		}
+		if i >= len(fed) || fed[i].Offset != tok.Offset || !bytes.Equal(fed[i].Raw, tok.Raw) {
+			t.Errorf("expected %v fed byte by byte", tok)
+		}
+		i++
+	}
+}
+
+func TestSGR(t *testing.T) {
+	const input = "\x1b[38:2::1:2:3;48;5;100;58:2::4:5:6mA" +
+		"\x1b[38;2;7;8;9;1;58:5:12mB" +
+		"\x1b[59;4:3;38:2:10:11:12mC" +
+		"\x1b[mD"
+
+	p := New(&parser.Options{Palette: palette.VGA})
+	if err := p.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if w := p.Warnings(); len(w) > 0 {
+		t.Fatalf("unexpected warnings %v", w)
+	}
+
+	rgb := func(c int) color.Color {
+		if c < 0 || c >= len(p.Palette) {
+			return nil
+		}
+		return p.Palette[c]
+	}
+	tests := []struct {
+		color, background, underline color.Color
+	}{
+		{color.RGBA{1, 2, 3, 0xff}, palette.VGA[100], color.RGBA{4, 5, 6, 0xff}},
+		{color.RGBA{7, 8, 9, 0xff}, palette.VGA[100], palette.VGA[12]},
+		{color.RGBA{10, 11, 12, 0xff}, palette.VGA[100], nil},
+		{palette.VGA[buffer.DefaultColor], palette.VGA[buffer.DefaultBackground], nil},
+	}
+	for x, test := range tests {
+		tile := p.Buffer().TileAt(x, 0)
+		if c := rgb(tile.Color); c != test.color {
+			t.Errorf("%c: expected color %v, got %v", tile.Char, test.color, c)
+		}
+		if c := rgb(tile.Background); c != test.background {
+			t.Errorf("%c: expected background %v, got %v", tile.Char, test.background, c)
+		}
+		if c := rgb(tile.UnderlineColor); c != test.underline {
+			t.Errorf("%c: expected underline color %v, got %v", tile.Char, test.underline, c)
+		}
+	}
+}
+
+func TestMusic(t *testing.T) {
+	const input = "a\x1b[MFT200L8CDE\x0eb\x1b[Nc\r\nd\x1b[NZ\x0e"
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	tunes := p.Music()
+	if len(tunes) != 1 {
+		t.Fatalf("expected 1 tune, got %+v", tunes)
+	}
+	if tune := tunes[0]; tune.Offset != 1 || tune.Source != "FT200L8CDE" || len(tune.Notes) != 3 || tune.Notes[2].Pitch != 76 {
+		t.Errorf("unexpected tune %+v", tune)
+	}
+
+	// Strings that aren't music are displayed
+	if s := p.String(); s != "abc\ndZ\x0e\n" {
+		t.Errorf("expected music to be removed from the text, got %q", s)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var frames []Frame
+	p := New(nil)
+	p.Recorder = NewBaudRecorder(2400, 60, func(f *Frame) error {
+		c := *f
+		c.Buffer = nil
+		frames = append(frames, c)
+		return nil
+	})
+	if p.Recorder.Every != 4 || p.Recorder.Delay != time.Second/60 {
+		t.Fatalf("unexpected recorder %+v", p.Recorder)
+	}
+
+	// The frames split the input, the final state is always recorded
+	if err := p.Parse(strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{4, 8, 10}
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d frames, got %+v", len(want), frames)
+	}
+	for i, offset := range want {
+		if f := frames[i]; f.Index != i || f.Offset != offset || f.Time != time.Duration(i)*p.Recorder.Delay {
+			t.Errorf("frame %d: unexpected %+v", i, f)
+		}
+	}
+}
+
+func TestBlink(t *testing.T) {
+	const input = "a\x1b[5mb"
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if a := p.Buffer().TileAt(1, 0).Attributes; a&attribute.Blink == 0 {
+		t.Errorf("expected the blink attribute, got %#x", a)
+	}
+	if html, _ := p.HTML(false); !strings.Contains(html, ` bl">b`) {
+		t.Errorf("expected blink class in %q", html)
+	}
+
+	// The iCE colors option is passed to the buffer
+	p = New(&parser.Options{NonBlink: true})
+	if err := p.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Buffer().HasBlink() {
+		t.Error("expected no blinking text with iCE colors")
+	}
+}
+
+func TestAspectRatio(t *testing.T) {
+	p := New(&parser.Options{AspectRatio: sauce.AspectRatioStretch, Screen: true, Width: 4, Height: 3})
+	p.Buffer().Resampling = buffer.Smooth
+	if err := p.Parse(strings.NewReader("A")); err != nil {
+		t.Fatal(err)
+	}
+	if a := p.Buffer().Flags.AspectRatio; a != sauce.AspectRatioStretch {
+		t.Errorf("expected the aspect ratio of the options, got %d", a)
+	}
+	if h := p.History(); h.Flags.AspectRatio != sauce.AspectRatioStretch || h.Resampling != buffer.Smooth {
+		t.Errorf("expected the history to keep the aspect ratio, got %d and %d", h.Flags.AspectRatio, h.Resampling)
+	}
+}
+
+func TestRender(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 16))
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("\x1b[44m ")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := p.Render(f, &buffer.RenderOptions{Scale: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Bounds().Size(); s != image.Pt(16, 32) {
+		t.Errorf("expected size 16x32, got %s", s)
+	}
+	if c := color.RGBAModel.Convert(m.At(0, 0)); c != p.Palette[4] {
+		t.Errorf("expected the piece palette, got %v", c)
+	}
+}
+
+func TestAlternateFonts(t *testing.T) {
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("a\x1b[11mb\x1b[19mc\x1b[10md")); err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []int{0, 1, 9, 0} {
+		if n := p.Buffer().TileAt(x, 0).Font; n != want {
+			t.Errorf("%d: expected font %d, got %d", x, want, n)
+		}
+	}
+}
@@ -40,8 +40,9 @@ const (
 	NP = FF
 )
 
-func isAlpha(c byte) bool {
-	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+// isFinal checks if c is the final byte of a control sequence
+func isFinal(c byte) bool {
+	return c >= 0x40 && c <= 0x7e
 }
 
 func isDigit(c byte) bool {
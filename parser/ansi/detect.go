@@ -0,0 +1,31 @@
+package ansi
+
+import "bytes"
+
+// score the likelihood that the sample is ANSi or ASCII text. Escape
+// sequences are strong evidence, plain text is weak evidence.
+func score(sample []byte, size int64) float64 {
+	if len(sample) == 0 {
+		return 0
+	}
+
+	if n := bytes.Count(sample, []byte{ESC, '['}); n > 0 {
+		// Saturates at one escape sequence per 50 bytes
+		density := float64(n*50) / float64(len(sample))
+		if density > 1 {
+			density = 1
+		}
+		return 0.6 + 0.4*density
+	}
+
+	var text int
+	for _, c := range sample {
+		if isPrint(c) || c >= 0x80 || c == CR || c == LF || c == TAB || c == SUB {
+			text++
+		}
+	}
+	if ratio := float64(text) / float64(len(sample)); ratio > 0.95 {
+		return 0.25 * ratio
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package ansi
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/palette"
+	"git.maze.io/maze/go-piece/parser"
+)
+
+// decGraphics maps the DEC special graphics characters 0x5f-0x7e to code page
+// 437. Characters without code page 437 equivalent map to a question mark.
+var decGraphics = [...]byte{
+	' ',  // _ Blank
+	0x04, // ` Diamond
+	0xb1, // a Checkerboard
+	'?',  // b HT symbol
+	'?',  // c FF symbol
+	'?',  // d CR symbol
+	'?',  // e LF symbol
+	0xf8, // f Degree symbol
+	0xf1, // g Plus/minus
+	'?',  // h NL symbol
+	'?',  // i VT symbol
+	0xd9, // j Lower right corner
+	0xbf, // k Upper right corner
+	0xda, // l Upper left corner
+	0xc0, // m Lower left corner
+	0xc5, // n Crossing lines
+	0xc4, // o Scan line 1
+	0xc4, // p Scan line 3
+	0xc4, // q Horizontal line (scan line 5)
+	0xc4, // r Scan line 7
+	'_',  // s Scan line 9
+	0xc3, // t Left tee
+	0xb4, // u Right tee
+	0xc1, // v Bottom tee
+	0xc2, // w Top tee
+	0xb3, // x Vertical bar
+	0xf3, // y Less than or equal to
+	0xf2, // z Greater than or equal to
+	0xe3, // { Pi
+	'?',  // | Not equal to
+	0x9c, // } Pound sign
+	0xfa, // ~ Centered dot
+}
+
+// savedCursor is the state saved by DECSC
+type savedCursor struct {
+	cursor   buffer.Cursor
+	graphics bool
+}
+
+// escape handles an escape sequence that isn't a control sequence or
+// control string.
+func (p *ANSI) escape(intermediate []byte, final byte) (err error) {
+	switch string(intermediate) {
+	case "":
+		switch final {
+		case '7': // Save Cursor (DEC)
+			p.saved = &savedCursor{*p.buffer.Cursor, p.graphics}
+		case '8': // Restore Cursor (DEC)
+			if p.saved != nil {
+				*p.buffer.Cursor = p.saved.cursor
+				p.graphics = p.saved.graphics
+			}
+		case 'c': // Reset to Initial State
+			p.resetState()
+		case 'D': // Index
+			p.index()
+		case 'E': // Next Line
+			p.buffer.Cursor.X = 0
+			p.index()
+		case 'H': // Character Tabulation Set
+			p.setTab(p.buffer.Cursor.X, true)
+		case 'M': // Reverse Index
+			err = p.reverseIndex()
+		case '=', '>': // Keypad modes
+		default:
+			err = p.warn(parser.WarningUnsupported, "unsupported escape sequence")
+		}
+	case "(": // Designate G0 character set
+		p.graphics = final == '0'
+	case ")", "*", "+": // Designate G1-G3 character set, we don't shift
+	default:
+		err = p.warn(parser.WarningUnsupported, "unsupported escape sequence")
+	}
+	p.clampCursor()
+	return
+}
+
+// resetState resets the terminal to its initial state, the scrollback is
+// kept.
+func (p *ANSI) resetState() {
+	p.buffer.Clear()
+	p.buffer.Cursor = buffer.NewCursor(0, 0)
+	p.Palette = p.initial
+	p.graphics = false
+	p.hasLast = false
+	p.save = nil
+	p.saved = nil
+	p.wrap = false
+	p.resetMargins()
+	p.resetTabs()
+}
+
+// controlString handles the contents of an operating system command.
+func (p *ANSI) controlString(data []byte) (err error) {
+	args := strings.Split(string(data), ";")
+	switch args[0] {
+	case "0", "2": // Icon name and window title, window title
+		p.title = strings.Join(args[1:], ";")
+	case "1": // Icon name
+	case "4": // Change color number
+		for i := 1; i+1 < len(args) && err == nil; i += 2 {
+			err = p.changeColor(args[i], args[i+1])
+		}
+	case "104": // Reset color number
+		if len(args) == 1 || (len(args) == 2 && args[1] == "") {
+			p.Palette = p.initial
+			break
+		}
+		for _, arg := range args[1:] {
+			if c, errc := strconv.Atoi(arg); errc == nil && c >= 0 && c < len(p.Palette) {
+				if c < len(p.initial) {
+					p.setColor(c, p.initial[c])
+				}
+			}
+		}
+	default:
+		err = p.warn(parser.WarningUnsupported, "unsupported operating system command")
+	}
+	return
+}
+
+// changeColor handles a color change or query, for OSC 4
+func (p *ANSI) changeColor(index, spec string) error {
+	c, err := strconv.Atoi(index)
+	if err != nil || c < 0 || c > 255 {
+		return p.warn(parser.WarningMalformed, "invalid color number %q", index)
+	}
+
+	if spec == "?" {
+		var r, g, b uint32
+		if c < len(p.Palette) {
+			r, g, b, _ = p.Palette[c].RGBA()
+		}
+		return p.respond("\x1b]4;%d;rgb:%04x/%04x/%04x\x1b\\", c, r, g, b)
+	}
+
+	rgba, ok := parseColorSpec(spec)
+	if !ok {
+		return p.warn(parser.WarningMalformed, "invalid color %q", spec)
+	}
+	if c >= len(p.Palette) {
+		if err = p.options.Limits.CheckColors(c + 1); err != nil {
+			return err
+		}
+	}
+	p.setColor(c, rgba)
+	return nil
+}
+
+// setColor sets palette entry c, the palette is extended with the VGA colors
+// if it has less than c colors.
+func (p *ANSI) setColor(c int, rgba color.Color) {
+	if palette.IsBuiltin(p.Palette) || (len(p.initial) > 0 && &p.Palette[0] == &p.initial[0]) {
+		p.Palette = p.Palette.Copy()
+	}
+	for i := len(p.Palette); i <= c; i++ {
+		if i < len(palette.VGA) {
+			p.Palette = append(p.Palette, palette.VGA[i])
+		} else {
+			p.Palette = append(p.Palette, color.RGBA{A: 0xff})
+		}
+	}
+	p.Palette[c] = rgba
+}
+
+// parseColorSpec parses a X11 color specification in the rgb:r/g/b or #rgb
+// notation, with 1 to 4 hex digits per component.
+func parseColorSpec(spec string) (c color.RGBA, ok bool) {
+	var parts []string
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		parts = strings.Split(spec[4:], "/")
+	case strings.HasPrefix(spec, "#") && len(spec) > 1 && (len(spec)-1)%3 == 0:
+		n := (len(spec) - 1) / 3
+		for i := 1; i < len(spec); i += n {
+			parts = append(parts, spec[i:i+n])
+		}
+	}
+	if len(parts) != 3 {
+		return
+	}
+
+	var v [3]uint8
+	for i, part := range parts {
+		if len(part) < 1 || len(part) > 4 {
+			return
+		}
+		n, err := strconv.ParseUint(part, 16, 16)
+		if err != nil {
+			return
+		}
+		// Scale to 8 bits
+		max := uint64(1)<<(4*uint(len(part))) - 1
+		v[i] = uint8(n * 0xff / max)
+	}
+	return color.RGBA{v[0], v[1], v[2], 0xff}, true
+}
+
+// Title returns the window title set by the piece.
+func (p *ANSI) Title() string {
+	return p.title
+}
@@ -0,0 +1,111 @@
+package ansi
+
+import (
+	"strings"
+
+	"git.maze.io/maze/go-piece/music"
+	"git.maze.io/maze/go-piece/parser"
+)
+
+// maxMusicSize is the maximum size of a music string
+const maxMusicSize = 64 * 1024
+
+// musicChars are the characters that can appear in a music string
+const musicChars = "ABCDEFGLMNOPST0123456789#+-.<> ;abcdefglmnopst"
+
+// musicCapture is an ANSi music string being captured, it starts with
+// CSI M, CSI N or CSI | and ends with SO.
+type musicCapture struct {
+	start *Token
+	text  []byte
+}
+
+// startMusic checks if a control sequence starts a music string
+func (p *ANSI) startMusic(t *Token) bool {
+	if t.Private != 0 || len(t.Params) > 0 || len(t.Intermediate) > 0 {
+		return false
+	}
+	switch t.Final {
+	case 'M', 'N', '|':
+		p.music = &musicCapture{start: t}
+		return true
+	}
+	return false
+}
+
+// captureMusic handles a token while a music string is captured
+func (p *ANSI) captureMusic(t *Token) (err error) {
+	m := p.music
+	switch t.Kind {
+	case TokenText:
+		for _, ch := range t.Data {
+			if strings.IndexByte(musicChars, ch) < 0 || len(m.text) >= maxMusicSize {
+				// Not music after all
+				return p.replayMusic(t)
+			}
+		}
+		m.text = append(m.text, t.Data...)
+		return
+
+	case TokenControl:
+		if t.Final == SO {
+			p.music = nil
+			return p.playMusic(m)
+		}
+	}
+	return p.replayMusic(t)
+}
+
+// playMusic interprets a captured music string
+func (p *ANSI) playMusic(m *musicCapture) (err error) {
+	tune := music.Tune{
+		Offset: m.start.Offset,
+		Source: string(m.text),
+	}
+	play := tune.Source
+	if m.start.Final == 'M' && len(play) > 0 && strings.IndexByte("FBNLSfbnls", play[0]) >= 0 {
+		// The final byte of CSI M is part of the music mode command
+		play = "M" + play
+	}
+	notes, errs := p.player.Play(play)
+	tune.Notes = notes
+	tune.Background = p.player.Background
+	p.tunes = append(p.tunes, tune)
+	if errs != nil {
+		p.token = m.start
+		err = p.warn(parser.WarningMalformed, "%v", errs)
+	}
+	return
+}
+
+// replayMusic handles the tokens of a capture that turned out not to be a
+// music string, followed by token t. A nil token ends the input.
+func (p *ANSI) replayMusic(t *Token) (err error) {
+	m := p.music
+	p.music = nil
+
+	p.token = m.start
+	if err = p.controlSequence(m.start); err != nil {
+		return
+	}
+	if len(m.text) > 0 {
+		text := &Token{
+			Kind:   TokenText,
+			Offset: m.start.Offset + int64(len(m.start.Raw)),
+			Raw:    m.text,
+			Data:   m.text,
+		}
+		if err = p.handle(text); err != nil {
+			return
+		}
+	}
+	if t != nil {
+		err = p.handle(t)
+	}
+	return
+}
+
+// Music returns the ANSi music found in the input.
+func (p *ANSI) Music() []music.Tune {
+	return p.tunes
+}
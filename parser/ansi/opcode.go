@@ -1,40 +1,43 @@
 package ansi
 
 import (
-	"errors"
+	"fmt"
 	"image/color"
-	"log"
 	"strconv"
 
 	"git.maze.io/maze/go-piece/buffer"
 	"git.maze.io/maze/go-piece/buffer/attribute"
 	"git.maze.io/maze/go-piece/math"
 	"git.maze.io/maze/go-piece/palette"
+	"git.maze.io/maze/go-piece/parser"
 )
 
-func addRGB(p *palette.Palette, r, g, b uint8) int {
-	if palette.IsBuiltin(*p) {
-		*p = p.Copy()
-	}
-
-	var found bool
-	var c int
-	for c = range *p {
-		switch t := (*p)[c].(type) {
-		case *color.RGBA:
-			if t.R == r && t.G == g && t.B == b {
-				found = true
-			}
+// addRGB returns the palette index of an RGB color, the color is added to the
+// palette if it isn't present yet.
+func (p *ANSI) addRGB(r, g, b uint8) (int, error) {
+	rgba := color.RGBA{r, g, b, 0xff}
+	for c, o := range p.Palette {
+		if o == rgba {
+			return c, nil
 		}
 	}
 
-	if !found {
-		rgba := color.RGBA{r, g, b, 0xff}
-		c = len(*p)
-		(*p) = append((*p), rgba)
+	if err := p.options.Limits.CheckColors(len(p.Palette) + 1); err != nil {
+		return 0, err
+	}
+	if palette.IsBuiltin(p.Palette) {
+		p.Palette = p.Palette.Copy()
 	}
+	p.Palette = append(p.Palette, rgba)
+	return len(p.Palette) - 1, nil
+}
 
-	return c
+// Cursor Backward Tabulation
+func (p *ANSI) parseCBT(s *Sequence) (err error) {
+	for n := math.MaxInt(1, s.Int(0)); n > 0; n-- {
+		p.buffer.Cursor.X = p.prevTab(p.buffer.Cursor.X)
+	}
+	return
 }
 
 // Cursor Character Absolute
@@ -43,29 +46,44 @@ func (p *ANSI) parseCHA(s *Sequence) (err error) {
 	if s.Len() > 0 {
 		x = s.Int(0) - 1
 	}
-	p.buffer.Cursor.X = math.MaxInt(0, x)
+	p.buffer.Cursor.X = math.MaxInt(0, math.MinInt(x, p.buffer.Width-1))
+	return
+}
+
+// Cursor Forward Tabulation
+func (p *ANSI) parseCHT(s *Sequence) (err error) {
+	for n := math.MaxInt(1, s.Int(0)); n > 0; n-- {
+		p.buffer.Cursor.X = p.nextTab(p.buffer.Cursor.X)
+	}
 	return
 }
 
 // Cursor Next Line
 func (p *ANSI) parseCNL(s *Sequence) (err error) {
-	y := 1
-	if s.Len() > 0 {
-		y = s.Int(0)
-	}
 	p.buffer.Cursor.X = 0
-	p.buffer.Cursor.Down(y)
+	p.buffer.Cursor.Down(math.MaxInt(1, s.Int(0)))
 	return
 }
 
 // Cursor Preceding Line
 func (p *ANSI) parseCPL(s *Sequence) (err error) {
-	y := 1
-	if s.Len() > 0 {
-		y = s.Int(0)
-	}
 	p.buffer.Cursor.X = 0
-	p.buffer.Cursor.Up(y)
+	p.buffer.Cursor.Up(math.MaxInt(1, s.Int(0)))
+	return
+}
+
+// Cursor Tabulation Control
+func (p *ANSI) parseCTC(s *Sequence) (err error) {
+	switch s.StringAt(0) {
+	case "", "0": // Set a tab stop at the cursor
+		p.setTab(p.buffer.Cursor.X, true)
+	case "2": // Clear the tab stop at the cursor
+		p.setTab(p.buffer.Cursor.X, false)
+	case "4", "5": // Clear all tab stops
+		p.clearTabs()
+	case "?5": // Reset the tab stops (DECST8C)
+		p.resetTabs()
+	}
 	return
 }
 
@@ -83,7 +101,9 @@ func (p *ANSI) parseCUD(s *Sequence) (err error) {
 
 // Cursor Right
 func (p *ANSI) parseCUF(s *Sequence) (err error) {
-	p.buffer.Cursor.Right(math.MaxInt(1, s.Int(0)))
+	// The cursor stops at the end of the line, like CHA
+	x := p.buffer.Cursor.X + math.MaxInt(1, s.Int(0))
+	p.buffer.Cursor.X = math.MaxInt(0, math.MinInt(x, p.buffer.Width-1))
 	return
 }
 
@@ -108,6 +128,63 @@ func (p *ANSI) parseCUU(s *Sequence) (err error) {
 	return
 }
 
+// Device Attributes
+func (p *ANSI) parseDA(s *Sequence) (err error) {
+	switch s.StringAt(0) {
+	case "", "0": // Primary, VT100 with Advanced Video Option
+		return p.respond("\x1b[?1;2c")
+	case ">", ">0": // Secondary, VT100 without firmware version
+		return p.respond("\x1b[>0;0;0c")
+	}
+	return
+}
+
+// Delete Character
+func (p *ANSI) parseDCH(s *Sequence) (err error) {
+	p.buffer.DeleteChars(p.buffer.Cursor.X, p.buffer.Cursor.Y, math.MaxInt(1, s.Int(0)))
+	return
+}
+
+// Delete Line
+func (p *ANSI) parseDL(s *Sequence) (err error) {
+	n := math.MaxInt(1, s.Int(0))
+	if p.options.Screen {
+		if r, ok := p.belowCursor(); ok {
+			p.buffer.ScrollUp(r, n)
+		}
+		return
+	}
+	p.buffer.DeleteLines(p.buffer.Cursor.Y, n)
+	return
+}
+
+// Device Status Report
+func (p *ANSI) parseDSR(s *Sequence) (err error) {
+	switch s.StringAt(0) {
+	case "5": // Operating status, no malfunction
+		return p.respond("\x1b[0n")
+	case "6": // Active Position Report
+		return p.respond("\x1b[%d;%dR", p.buffer.Cursor.Y+1, p.buffer.Cursor.X+1)
+	case "?6": // Extended Cursor Position Report, on page 1
+		return p.respond("\x1b[?%d;%d;1R", p.buffer.Cursor.Y+1, p.buffer.Cursor.X+1)
+	}
+	return
+}
+
+// respond writes a reply to a query to the Response writer, if set
+func (p *ANSI) respond(format string, v ...interface{}) (err error) {
+	if p.Response != nil {
+		_, err = fmt.Fprintf(p.Response, format, v...)
+	}
+	return
+}
+
+// Erase Character
+func (p *ANSI) parseECH(s *Sequence) (err error) {
+	p.buffer.EraseChars(p.buffer.Cursor.X, p.buffer.Cursor.Y, math.MaxInt(1, s.Int(0)))
+	return
+}
+
 // Erase Display
 func (p *ANSI) parseED(s *Sequence) (err error) {
 	i := s.Int(0)
@@ -133,17 +210,17 @@ func (p *ANSI) parseEL(s *Sequence) (err error) {
 	switch i {
 	case 0: // To EOL
 		o = (p.buffer.Width * (p.buffer.Cursor.Y)) + p.buffer.Cursor.X
-		e = (p.buffer.Width * (p.buffer.Cursor.Y + 1)) - 1
-	case 1: // To BOL
-		o = (p.buffer.Width * (p.buffer.Cursor.Y - 1)) + 1
-		e = (p.buffer.Width * (p.buffer.Cursor.Y)) + p.buffer.Cursor.X
+		e = (p.buffer.Width * (p.buffer.Cursor.Y + 1))
+	case 1: // To BOL, including the cursor
+		o = (p.buffer.Width * (p.buffer.Cursor.Y))
+		e = (p.buffer.Width * (p.buffer.Cursor.Y)) + p.buffer.Cursor.X + 1
 	case 2: // From BOL to EOL
-		o = (p.buffer.Width * (p.buffer.Cursor.Y - 1)) + 1
-		e = (p.buffer.Width * (p.buffer.Cursor.Y + 1)) - 1
+		o = (p.buffer.Width * (p.buffer.Cursor.Y))
+		e = (p.buffer.Width * (p.buffer.Cursor.Y + 1))
 	}
 
 	o = math.MaxInt(o, 0)
-	e = math.MinInt(e, p.buffer.Len())
+	e = math.MinInt(e, len(p.buffer.Tiles))
 
 	for i = o; i < e; i++ {
 		p.buffer.ClearAt(i)
@@ -152,19 +229,53 @@ func (p *ANSI) parseEL(s *Sequence) (err error) {
 	return
 }
 
+// Insert Character
+func (p *ANSI) parseICH(s *Sequence) (err error) {
+	p.buffer.InsertChars(p.buffer.Cursor.X, p.buffer.Cursor.Y, math.MaxInt(1, s.Int(0)))
+	return
+}
+
 // Insert Line
 func (p *ANSI) parseIL(s *Sequence) (err error) {
-	i := 1
-	if s.Len() > 0 {
-		i = s.Int(0)
+	i := math.MaxInt(1, s.Int(0))
+	if p.options.Screen {
+		if r, ok := p.belowCursor(); ok {
+			p.buffer.ScrollDown(r, i)
+		}
+		return
 	}
 	o := p.buffer.Width * p.buffer.Normalize().Cursor.Y
-	for ; i > 0; i-- {
-		p.buffer.Insert(o, p.buffer.Width)
+	for ; i > 0 && err == nil; i-- {
+		err = p.buffer.Insert(o, p.buffer.Width)
 	}
 	return
 }
 
+// Repeat the preceding graphic character
+func (p *ANSI) parseREP(s *Sequence) (err error) {
+	if !p.hasLast {
+		return
+	}
+	for n := p.repeatCount(math.MaxInt(1, s.Int(0))); n > 0 && err == nil; n-- {
+		err = p.putChar(p.last)
+	}
+	return
+}
+
+// repeatCount caps the count of a repeat to a screen full of characters in
+// screen mode. Otherwise the count is capped to one past the tiles remaining
+// within the limits, so that the limit error is still returned.
+func (p *ANSI) repeatCount(n int) int {
+	if p.options.Screen {
+		return math.MinInt(n, p.buffer.Width*p.buffer.Height)
+	}
+	if l := p.buffer.Limits.Tiles; l > 0 {
+		o := p.buffer.Cursor.Offset(p.buffer.Width)
+		return math.MinInt(n, math.MaxInt(0, l-o)+1)
+	}
+	return n
+}
+
 // Reset Mode
 func (p *ANSI) parseRM(s *Sequence) (err error) {
 	b := s.Bytes()
@@ -180,7 +291,7 @@ func (p *ANSI) parseRM(s *Sequence) (err error) {
 		}
 	default:
 		// TODO implement mode switching
-		log.Printf("ansi: unsupported mode %s\n", s)
+		return p.warn(parser.WarningUnsupported, "unsupported mode %s", s)
 	}
 	return
 
@@ -201,14 +312,15 @@ func (p *ANSI) parseSM(s *Sequence) (err error) {
 		}
 	default:
 		// TODO implement mode switching
-		log.Printf("ansi: unsupported mode %s\n", s)
+		return p.warn(parser.WarningUnsupported, "unsupported mode %s", s)
 	}
 	return
 }
 
 func (p *ANSI) parseSGR(s *Sequence) (err error) {
-	for i, n := range s.Ints() {
-		switch n {
+	for i := 0; i < s.Len(); i++ {
+		param := s.Param(i)
+		switch n := param.Value(0); n {
 		// ECMA-48 standard codes
 		case 0: // Default rendition
 			p.buffer.Cursor.ResetAttributes()
@@ -218,9 +330,21 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 			p.buffer.Cursor.Attributes |= attribute.Faint
 		case 3: // Italicized
 			p.buffer.Cursor.Attributes |= attribute.Italics
-		case 4: // Underlined
-			p.buffer.Cursor.Attributes &^= attribute.DoubleUnderline
-			p.buffer.Cursor.Attributes |= attribute.Underline
+		case 4: // Underlined, with an optional style (ISO 8613-6)
+			style := 1
+			if len(param) > 1 {
+				style = param[1:].Value(0)
+			}
+			p.buffer.Cursor.Attributes &^= attribute.Underline | attribute.DoubleUnderline | attribute.CurlyUnderline
+			switch style {
+			case 0: // Not underlined
+			case 2: // Doubly underlined
+				p.buffer.Cursor.Attributes |= attribute.DoubleUnderline
+			case 3: // Curly underlined
+				p.buffer.Cursor.Attributes |= attribute.CurlyUnderline
+			default: // Single, dotted and dashed
+				p.buffer.Cursor.Attributes |= attribute.Underline
+			}
 		case 5, 6: // Blink
 			p.buffer.Cursor.Attributes |= attribute.Blink
 		case 7: // Negative
@@ -235,6 +359,7 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 			p.buffer.Cursor.Attributes |= attribute.Gothic
 		case 21: // Doubly underlined
 			p.buffer.Cursor.Attributes &^= attribute.Underline
+			p.buffer.Cursor.Attributes &^= attribute.CurlyUnderline
 			p.buffer.Cursor.Attributes |= attribute.DoubleUnderline
 		case 22: // Neither bold nor faint
 			p.buffer.Cursor.Attributes &^= attribute.Bold
@@ -245,6 +370,7 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 		case 24: // Not underlined
 			p.buffer.Cursor.Attributes &^= attribute.Underline
 			p.buffer.Cursor.Attributes &^= attribute.DoubleUnderline
+			p.buffer.Cursor.Attributes &^= attribute.CurlyUnderline
 		case 25: // Not blinking
 			p.buffer.Cursor.Attributes &^= attribute.Blink
 		case 26: // Reserved
@@ -257,73 +383,25 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 		case 30, 31, 32, 33, 34, 35, 36, 37:
 			p.buffer.Cursor.Color = n - 30
 		case 38: // Extended set foreground color
-			if i > 0 {
-				s.Shift(i)
-			}
-			if s.Len() < 3 {
-				log.Printf("broken extended set background <ESC>[%s\n", s)
+			var c, used int
+			if c, used, err = p.extendedColor(s, i); err != nil {
 				return
+			} else if c >= 0 {
+				p.buffer.Cursor.Color = c
 			}
-			switch s.Int(1) {
-			case 2: // RGB color
-				if s.Len() >= 5 {
-					r := uint8(s.Int(2))
-					g := uint8(s.Int(3))
-					b := uint8(s.Int(4))
-					p.buffer.Cursor.Color = addRGB(&p.Palette, r, g, b)
-				} else {
-					log.Printf("broken RGB color in sequence <ESC>[%s\n", s)
-					return
-				}
-				s.Shift(5)
-			case 5: // VGA color index
-				if palette.IsBuiltin(p.Palette) && len(p.Palette) == 16 {
-					p.Palette = palette.VGA
-				}
-				p.buffer.Cursor.Color = s.Int(2)
-				s.Shift(3)
-			}
-			// It is, in theory, valid to have more sequences, so parse them too
-			if s.Len() > 0 {
-				p.parseSGR(s)
-			}
-			return
+			i += used
 		case 39: // Default display colour
 			p.buffer.Cursor.Color = buffer.DefaultColor
 		case 40, 41, 42, 43, 44, 45, 46, 47:
 			p.buffer.Cursor.Background = n - 40
 		case 48: // Extended set background color
-			if i > 0 {
-				s.Shift(i)
-			}
-			if s.Len() < 3 {
-				log.Printf("broken extended set background <ESC>[%s\n", s)
+			var c, used int
+			if c, used, err = p.extendedColor(s, i); err != nil {
 				return
+			} else if c >= 0 {
+				p.buffer.Cursor.Background = c
 			}
-			switch s.Int(1) {
-			case 2: // RGB color
-				if s.Len() >= 5 {
-					r := uint8(s.Int(2))
-					g := uint8(s.Int(3))
-					b := uint8(s.Int(4))
-					p.buffer.Cursor.Background = addRGB(&p.Palette, r, g, b)
-				} else {
-					log.Printf("broken RGB color in sequence <ESC>[%s\n", s)
-					return
-				}
-				s.Shift(5)
-			case 5: // VGA color index
-				if palette.IsBuiltin(p.Palette) && len(p.Palette) == 16 {
-					p.Palette = palette.VGA
-				}
-				p.buffer.Cursor.Background = s.Int(2)
-				s.Shift(3)
-			}
-			// It is, in theory, valid to have more sequences, so parse them too
-			if s.Len() > 0 {
-				p.parseSGR(s)
-			}
-			return
+			i += used
 		case 49: // Default background colour
 			p.buffer.Cursor.Background = buffer.DefaultBackground
 		case 50: // Reserved (cancels 26)
@@ -338,7 +416,17 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 			p.buffer.Cursor.Attributes &^= attribute.Encircle
 		case 55: // Not overlined
 			p.buffer.Cursor.Attributes &^= attribute.Overline
-		case 56, 57, 58, 59: // Reserved
+		case 56, 57: // Reserved
+		case 58: // Set underline color (ISO 8613-6)
+			var c, used int
+			if c, used, err = p.extendedColor(s, i); err != nil {
+				return
+			} else if c >= 0 {
+				p.buffer.Cursor.UnderlineColor = c
+			}
+			i += used
+		case 59: // Default underline color
+			p.buffer.Cursor.UnderlineColor = buffer.DefaultUnderlineColor
 		case 60: // Ideogram underline
 			p.buffer.Cursor.Attributes |= attribute.IdeogramUnderline
 		case 61: // Ideogram double underline
@@ -363,15 +451,101 @@ func (p *ANSI) parseSGR(s *Sequence) (err error) {
 			p.buffer.Cursor.Background = n - 94
 
 		default: // Fallthrough
-			log.Printf("unsupported SGR %d (<ESC>[%s)\n", n, s)
+			if err = p.warn(parser.WarningUnsupported, "unsupported SGR %d", n); err != nil {
+				return
+			}
 		}
 	}
 
 	return
 }
 
+// extendedColor parses the extended color in SGR item i, which is either
+// colon separated (38:2::r:g:b or 38:5:n), or semicolon separated (38;2;r;g;b
+// or 38;5;n). It returns the palette index, or -1 if the color is malformed,
+// and the number of items consumed after item i.
+func (p *ANSI) extendedColor(s *Sequence, i int) (c, n int, err error) {
+	var (
+		args  []int
+		colon bool
+	)
+	if param := s.Param(i); len(param) > 1 {
+		args, colon = param[1:], true
+	} else {
+		for j := i + 1; j < s.Len(); j++ {
+			args = append(args, s.Param(j).Value(-1))
+		}
+	}
+
+	c = -1
+	if len(args) == 0 {
+		err = p.warn(parser.WarningMalformed, "incomplete extended color")
+		return
+	}
+	switch args[0] {
+	case 2: // RGB color
+		rgb := args[1:]
+		if colon && len(rgb) > 3 {
+			// Skip the color space identifier
+			rgb = rgb[1:]
+		}
+		if !colon {
+			n = math.MinInt(4, len(args))
+		}
+		if len(rgb) < 3 {
+			err = p.warn(parser.WarningMalformed, "incomplete RGB color")
+			return
+		}
+		c, err = p.addRGB(colorValue(rgb[0]), colorValue(rgb[1]), colorValue(rgb[2]))
+	case 5: // VGA color index
+		if !colon {
+			n = math.MinInt(2, len(args))
+		}
+		if len(args) < 2 || args[1] < 0 || args[1] > 255 {
+			err = p.warn(parser.WarningMalformed, "invalid color index")
+			return
+		}
+		if palette.IsBuiltin(p.Palette) && len(p.Palette) == 16 {
+			p.Palette = palette.VGA
+		}
+		c = args[1]
+	default:
+		// The remaining items can't be interpreted
+		if !colon {
+			n = len(args)
+		}
+		err = p.warn(parser.WarningUnsupported, "unsupported color space %d", args[0])
+	}
+	return
+}
+
+// colorValue clamps an RGB color component, omitted components are zero
+func colorValue(v int) uint8 {
+	return uint8(math.MaxInt(0, math.MinInt(v, 255)))
+}
+
+// Tabulation Clear
+func (p *ANSI) parseTBC(s *Sequence) (err error) {
+	switch s.Int(0) {
+	case 0: // Clear the tab stop at the cursor
+		p.setTab(p.buffer.Cursor.X, false)
+	case 2, 3, 5: // Clear all tab stops
+		p.clearTabs()
+	}
+	return
+}
+
+// Line Position Absolute
+func (p *ANSI) parseVPA(s *Sequence) (err error) {
+	p.buffer.Cursor.Y = math.MaxInt(0, s.Int(0)-1)
+	return
+}
+
 // Save Cursor Position
 func (p *ANSI) parseSCP(s *Sequence) (err error) {
+	if s.StringAt(0) != "" {
+		return p.parseDECSLRM(s)
+	}
 	p.save = buffer.NewCursor(p.buffer.Cursor.X, p.buffer.Cursor.Y)
 	return
 }
@@ -396,16 +570,16 @@ func (p *ANSI) parseXXX(s *Sequence) (err error) {
 		i = append(i, uint8(n))
 	}
 	if len(i) != 4 {
-		return errors.New("Expected a 4 element sequence")
+		return p.warn(parser.WarningMalformed, "expected a 4 element sequence")
 	}
 
 	switch i[0] {
 	case 0: // Background
-		p.buffer.Cursor.Background = addRGB(&p.Palette, i[1], i[2], i[3])
+		p.buffer.Cursor.Background, err = p.addRGB(i[1], i[2], i[3])
 	case 1: // Foreground
-		p.buffer.Cursor.Color = addRGB(&p.Palette, i[1], i[2], i[3])
+		p.buffer.Cursor.Color, err = p.addRGB(i[1], i[2], i[3])
 	default:
-		return errors.New("Unexpected 24 bit color selector")
+		return p.warn(parser.WarningMalformed, "unexpected 24 bit color selector %d", i[0])
 	}
 
 	return
@@ -0,0 +1,114 @@
+package ansi
+
+import (
+	"time"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/math"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// Frame is a snapshot of the buffer while the input is parsed.
+type Frame struct {
+	Index int
+
+	// Offset is the number of input bytes displayed
+	Offset int64
+
+	// Time the frame is displayed
+	Time time.Duration
+
+	// Buffer is the live buffer of the parser, it is only valid during the
+	// call to the frame function.
+	Buffer  *buffer.Buffer
+	Palette palette.Palette
+}
+
+// Recorder takes snapshots of the buffer while the input is parsed, to
+// replay an ANSImation. The final state is always recorded.
+type Recorder struct {
+	// Every is the number of input bytes between frames
+	Every int
+
+	// Delay is the time between frames
+	Delay time.Duration
+
+	// Frame is called for every snapshot
+	Frame func(*Frame) error
+
+	index int
+	next  int64 // Offset of the next frame
+	last  int64 // Offset of the last frame
+}
+
+// NewBaudRecorder returns a recorder that emulates the display of the input
+// at a baud rate, with fps frames per second. Every byte takes 10 bits on the
+// line.
+func NewBaudRecorder(baud, fps int, fn func(*Frame) error) *Recorder {
+	every := math.MaxInt(1, baud/10/fps)
+	return &Recorder{
+		Every: every,
+		Delay: time.Duration(every) * 10 * time.Second / time.Duration(baud),
+		Frame: fn,
+	}
+}
+
+// reset the recorder to take the first frame after offset
+func (r *Recorder) reset(offset int64) {
+	r.index = 0
+	r.next = offset + int64(math.MaxInt(1, r.Every))
+	r.last = -1
+}
+
+// feed tokenizes b, the input is split at the frames of the recorder
+func (p *ANSI) feed(b []byte) (n int, err error) {
+	r := p.Recorder
+	if r == nil {
+		n, err = p.tokenizer.Feed(b, p.handle)
+		p.offset += int64(n)
+		return
+	}
+
+	if r.next == 0 {
+		// Recorder set after the parser was reset
+		r.reset(p.offset)
+	}
+	for n < len(b) {
+		c := len(b) - n
+		if d := r.next - p.offset; d < int64(c) {
+			c = int(d)
+		}
+		var m int
+		m, err = p.tokenizer.Feed(b[n:n+c], p.handle)
+		n += m
+		p.offset += int64(m)
+		if err != nil {
+			return
+		}
+		if p.offset >= r.next {
+			r.next += int64(math.MaxInt(1, r.Every))
+			if err = p.snapshot(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// snapshot passes the current state to the recorder
+func (p *ANSI) snapshot() error {
+	r := p.Recorder
+	if r.Frame == nil || r.last == p.offset {
+		return nil
+	}
+	f := &Frame{
+		Index:   r.index,
+		Offset:  p.offset,
+		Time:    time.Duration(r.index) * r.Delay,
+		Buffer:  p.buffer,
+		Palette: p.Palette,
+	}
+	r.index++
+	r.last = p.offset
+	return r.Frame(f)
+}
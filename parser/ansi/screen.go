@@ -0,0 +1,188 @@
+package ansi
+
+import (
+	"image"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/math"
+)
+
+// resetMargins sets the scroll region to the full screen.
+func (p *ANSI) resetMargins() {
+	p.margin = image.Rect(0, 0, p.buffer.Width, p.buffer.Height)
+}
+
+// clampCursor keeps the cursor on the screen, in screen mode.
+func (p *ANSI) clampCursor() {
+	if p.options.Screen {
+		p.buffer.Cursor.Normalize(p.buffer.Width-1, p.buffer.Height-1)
+	}
+}
+
+// putChar writes a character at the cursor. In screen mode, the line wrap
+// is deferred until the next character is written, like a VT100 does, and a
+// wrap on the bottom line of the scroll region scrolls.
+func (p *ANSI) putChar(ch byte) (err error) {
+	if !p.options.Screen {
+		return p.buffer.PutChar(ch)
+	}
+
+	c := p.buffer.Cursor
+	if p.wrap && c.X == p.buffer.Width-1 && c.Y == p.wrapY {
+		c.X = 0
+		p.index()
+	}
+	p.wrap = false
+
+	y := c.Y
+	if err = p.buffer.PutChar(ch); err != nil {
+		return
+	}
+	if c.Y != y {
+		c.X, c.Y = p.buffer.Width-1, y
+		p.wrap, p.wrapY = true, y
+	}
+	return
+}
+
+// lineFeed moves the cursor to the start of the next line.
+func (p *ANSI) lineFeed() {
+	p.buffer.Cursor.X = 0
+	p.index()
+}
+
+// index moves the cursor down one line. In screen mode, the scroll region
+// scrolls up if the cursor is on its bottom line.
+func (p *ANSI) index() {
+	if !p.options.Screen {
+		p.buffer.Cursor.Y++
+		return
+	}
+	switch y := p.buffer.Cursor.Y; {
+	case y == p.margin.Max.Y-1:
+		p.scrollUp(1)
+	case y < p.buffer.Height-1:
+		p.buffer.Cursor.Y++
+	}
+}
+
+// reverseIndex moves the cursor up one line. The scroll region scrolls down
+// if the cursor is on its top line, without screen mode a line is inserted at
+// the top of the buffer.
+func (p *ANSI) reverseIndex() error {
+	y := p.buffer.Cursor.Y
+	switch {
+	case p.options.Screen && y == p.margin.Min.Y:
+		p.buffer.ScrollDown(p.margin, 1)
+	case y > 0:
+		p.buffer.Cursor.Y--
+	case !p.options.Screen:
+		return p.buffer.Insert(0, p.buffer.Width)
+	}
+	return nil
+}
+
+// scrollUp scrolls the scroll region up n lines. Lines that scroll off the top
+// of the screen are kept in the scrollback buffer.
+func (p *ANSI) scrollUp(n int) {
+	out := p.buffer.ScrollUp(p.margin, n)
+	if p.margin.Min.Y > 0 || p.margin.Dx() != p.buffer.Width {
+		return
+	}
+
+	p.scrollback = append(p.scrollback, out...)
+
+	// Drop the oldest lines, if the history exceeds the limits
+	l := p.options.Limits
+	rows := len(p.scrollback) / p.buffer.Width
+	if l.Height > 0 {
+		rows = math.MinInt(rows, math.MaxInt(0, l.Height-p.buffer.Height))
+	}
+	if l.Tiles > 0 {
+		rows = math.MinInt(rows, math.MaxInt(0, l.Tiles/p.buffer.Width-p.buffer.Height))
+	}
+	p.scrollback = p.scrollback[len(p.scrollback)-rows*p.buffer.Width:]
+}
+
+// History returns a buffer with the lines that scrolled off the screen,
+// followed by the screen. Without screen mode, there is no scrollback and the
+// buffer is returned as-is.
+func (p *ANSI) History() *buffer.Buffer {
+	if !p.options.Screen {
+		return p.buffer
+	}
+
+	rows := len(p.scrollback) / p.buffer.Width
+	b := buffer.New(p.buffer.Width, rows+p.buffer.Height)
+	b.Flags = p.buffer.Flags
+	b.BoldFont = p.buffer.BoldFont
+	b.Resampling = p.buffer.Resampling
+	copy(b.Tiles, p.scrollback)
+	copy(b.Tiles[len(p.scrollback):], p.buffer.Tiles)
+	b.SizeMaxToSize()
+	return b
+}
+
+// belowCursor returns the part of the scroll region from the cursor line
+// down, ok is false if the cursor is outside of the scroll region.
+func (p *ANSI) belowCursor() (r image.Rectangle, ok bool) {
+	r = p.margin
+	r.Min.Y = p.buffer.Cursor.Y
+	return r, image.Pt(r.Min.X, r.Min.Y).In(p.margin)
+}
+
+// Scroll Up
+func (p *ANSI) parseSU(s *Sequence) (err error) {
+	n := math.MaxInt(1, s.Int(0))
+	if p.options.Screen {
+		p.scrollUp(n)
+	} else {
+		p.buffer.DeleteLines(0, n)
+	}
+	return
+}
+
+// Scroll Down
+func (p *ANSI) parseSD(s *Sequence) (err error) {
+	if s.Len() > 1 {
+		// Mouse tracking (xterm)
+		return
+	}
+	n := math.MaxInt(1, s.Int(0))
+	if p.options.Screen {
+		p.buffer.ScrollDown(p.margin, n)
+	} else {
+		err = p.buffer.Insert(0, n*p.buffer.Width)
+	}
+	return
+}
+
+// Set Top and Bottom Margins (DEC)
+func (p *ANSI) parseDECSTBM(s *Sequence) (err error) {
+	top := math.MaxInt(1, s.Int(0))
+	bottom := p.buffer.Height
+	if s.Int(1) > 0 {
+		bottom = math.MinInt(s.Int(1), p.buffer.Height)
+	}
+	if top < bottom {
+		p.margin.Min.Y = top - 1
+		p.margin.Max.Y = bottom
+		p.buffer.Cursor.Goto(0, 0)
+	}
+	return
+}
+
+// Set Left and Right Margins (DEC)
+func (p *ANSI) parseDECSLRM(s *Sequence) (err error) {
+	left := math.MaxInt(1, s.Int(0))
+	right := p.buffer.Width
+	if s.Int(1) > 0 {
+		right = math.MinInt(s.Int(1), p.buffer.Width)
+	}
+	if left < right {
+		p.margin.Min.X = left - 1
+		p.margin.Max.X = right
+		p.buffer.Cursor.Goto(0, 0)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package ansi
+
+import "git.maze.io/maze/go-piece/math"
+
+// resetTabs sets a tab stop every tab stop width columns.
+func (p *ANSI) resetTabs() {
+	p.tabs = make([]bool, p.buffer.Width)
+	tabStop := p.options.Tab()
+	for x := tabStop; x < len(p.tabs); x += tabStop {
+		p.tabs[x] = true
+	}
+}
+
+// setTab sets or clears the tab stop at column x.
+func (p *ANSI) setTab(x int, set bool) {
+	if x >= 0 && x < len(p.tabs) {
+		p.tabs[x] = set
+	}
+}
+
+// clearTabs clears all tab stops.
+func (p *ANSI) clearTabs() {
+	for x := range p.tabs {
+		p.tabs[x] = false
+	}
+}
+
+// nextTab returns the column of the next tab stop after x, or the last
+// column if there are no more tab stops.
+func (p *ANSI) nextTab(x int) int {
+	for x++; x < len(p.tabs); x++ {
+		if p.tabs[x] {
+			return x
+		}
+	}
+	return len(p.tabs) - 1
+}
+
+// prevTab returns the column of the previous tab stop before x, or the first
+// column if there are no more tab stops.
+func (p *ANSI) prevTab(x int) int {
+	for x = math.MinInt(x, len(p.tabs)) - 1; x > 0; x-- {
+		if p.tabs[x] {
+			return x
+		}
+	}
+	return 0
+}
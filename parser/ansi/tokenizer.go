@@ -0,0 +1,400 @@
+package ansi
+
+import (
+	"fmt"
+	"io"
+)
+
+// TokenKind is the type of a token.
+type TokenKind int
+
+// Token kinds
+const (
+	TokenText    TokenKind = iota // Run of printable characters
+	TokenControl                  // C0 control character
+	TokenCSI                      // Control sequence
+	TokenESC                      // Escape sequence
+	TokenOSC                      // Operating system command
+	TokenDCS                      // Device control string
+	TokenString                   // Start of string, privacy message or application program command
+	TokenInvalid                  // Malformed or incomplete sequence
+)
+
+var tokenKinds = map[TokenKind]string{
+	TokenText:    "text",
+	TokenControl: "control",
+	TokenCSI:     "CSI",
+	TokenESC:     "ESC",
+	TokenOSC:     "OSC",
+	TokenDCS:     "DCS",
+	TokenString:  "string",
+	TokenInvalid: "invalid",
+}
+
+func (k TokenKind) String() string {
+	if s, ok := tokenKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// MaxStringSize is the maximum size of a control string, longer strings are
+// truncated.
+const MaxStringSize = 4096
+
+const (
+	maxParams = 64        // Maximum number of control sequence parameters
+	maxParam  = 1<<31 - 1 // Maximum parameter value
+)
+
+// Param is a control sequence parameter, with its colon separated
+// sub-parameters. Omitted values are -1.
+type Param []int
+
+// Value returns the parameter value, or d if the value is omitted.
+func (p Param) Value(d int) int {
+	if len(p) == 0 || p[0] < 0 {
+		return d
+	}
+	return p[0]
+}
+
+// Token is a unit of ANSi input.
+type Token struct {
+	Kind TokenKind
+
+	// Offset of the first byte of the token in the input
+	Offset int64
+
+	// Raw input of the token, control strings are truncated
+	Raw []byte
+
+	// Data is the text of a text token, or the contents of a control string
+	Data []byte
+
+	// Private marker of a control sequence ('<', '=', '>' or '?'), or zero
+	Private byte
+
+	// Params of a control sequence
+	Params []Param
+
+	// Intermediate bytes of a control or escape sequence
+	Intermediate []byte
+
+	// Final byte of a control or escape sequence, the character of a control
+	// token, or the introducer of a control string (']', 'P', 'X', '^' or
+	// '_')
+	Final byte
+}
+
+// Param returns the value of parameter i, or d if it is omitted.
+func (t *Token) Param(i, d int) int {
+	if i < len(t.Params) {
+		return t.Params[i].Value(d)
+	}
+	return d
+}
+
+func (t *Token) String() string {
+	return fmt.Sprintf("%s %q at offset %d", t.Kind, t.Raw, t.Offset)
+}
+
+// Tokenizer states
+const (
+	tokText = iota
+	tokEscape
+	tokIntermediate
+	tokCSI
+	tokString
+	tokStringEscape
+)
+
+// Tokenizer splits ANSi input in tokens. The tokenizer keeps its state
+// between calls, so the input may be split at any byte.
+type Tokenizer struct {
+	r      io.Reader
+	buf    []byte
+	queue  []*Token
+	err    error
+	state  int
+	offset int64
+	text   *Token
+	tok    *Token
+	bad    bool // Malformed control sequence
+}
+
+// NewTokenizer returns a tokenizer that reads from r. If the input is fed to
+// the tokenizer with Feed, r may be nil.
+func NewTokenizer(r io.Reader) *Tokenizer {
+	return &Tokenizer{r: r}
+}
+
+// Next returns the next token, or io.EOF at the end of the input.
+func (t *Tokenizer) Next() (*Token, error) {
+	for len(t.queue) == 0 {
+		if t.err != nil {
+			return nil, t.err
+		}
+		if t.buf == nil {
+			t.buf = make([]byte, 4096)
+		}
+		n, err := t.r.Read(t.buf)
+		t.Feed(t.buf[:n], t.enqueue)
+		if err != nil {
+			if err == io.EOF {
+				t.Flush(t.enqueue)
+			}
+			t.err = err
+		}
+	}
+	tok := t.queue[0]
+	t.queue = t.queue[1:]
+	return tok, nil
+}
+
+func (t *Tokenizer) enqueue(tok *Token) error {
+	t.queue = append(t.queue, tok)
+	return nil
+}
+
+// Feed tokenizes b and calls fn for every complete token. Text at the end of
+// b is passed to fn, so a run of text may be split over multiple tokens. If
+// fn returns an error, Feed stops and returns the number of bytes consumed.
+func (t *Tokenizer) Feed(b []byte, fn func(*Token) error) (n int, err error) {
+	for ; n < len(b); n++ {
+		ch := b[n]
+		if t.state == tokText && ch < 0x20 && t.text != nil {
+			if err = t.emitText(fn); err != nil {
+				return
+			}
+		}
+		err = t.feed(ch, t.offset, fn)
+		t.offset++
+		if err != nil {
+			n++
+			return
+		}
+	}
+	if t.text != nil {
+		err = t.emitText(fn)
+	}
+	return
+}
+
+// Flush passes an incomplete sequence at the end of the input to fn, as an
+// invalid token.
+func (t *Tokenizer) Flush(fn func(*Token) error) error {
+	if t.text != nil {
+		return t.emitText(fn)
+	}
+	if t.state != tokText {
+		return t.abort(fn)
+	}
+	return nil
+}
+
+func (t *Tokenizer) emitText(fn func(*Token) error) error {
+	tok := t.text
+	t.text = nil
+	return fn(tok)
+}
+
+// start a new token
+func (t *Tokenizer) start(kind TokenKind, offset int64, ch byte) {
+	t.tok = &Token{Kind: kind, Offset: offset, Raw: []byte{ch}}
+	t.bad = false
+}
+
+// emit the current token and return to the text state
+func (t *Tokenizer) emit(fn func(*Token) error) error {
+	tok := t.tok
+	t.tok = nil
+	t.state = tokText
+	if t.bad {
+		tok.Kind = TokenInvalid
+	}
+	return fn(tok)
+}
+
+// abort the current token, it is emitted as invalid token
+func (t *Tokenizer) abort(fn func(*Token) error) error {
+	t.bad = true
+	return t.emit(fn)
+}
+
+func (t *Tokenizer) feed(ch byte, offset int64, fn func(*Token) error) (err error) {
+	switch t.state {
+	case tokText:
+		switch {
+		case ch == ESC:
+			t.start(TokenESC, offset, ch)
+			t.state = tokEscape
+		case ch < 0x20:
+			return fn(&Token{Kind: TokenControl, Offset: offset, Raw: []byte{ch}, Final: ch})
+		default:
+			if t.text == nil {
+				t.text = &Token{Kind: TokenText, Offset: offset}
+			}
+			t.text.Raw = append(t.text.Raw, ch)
+			t.text.Data = t.text.Raw
+		}
+		return
+
+	case tokString:
+		switch {
+		case ch == ESC:
+			t.state = tokStringEscape
+		case ch == BEL && t.tok.Kind == TokenOSC: // Terminator (xterm)
+			t.tok.Raw = append(t.tok.Raw, ch)
+			return t.emit(fn)
+		case ch == CAN || ch == SUB:
+			return t.cancel(ch, offset, fn)
+		case ch < 0x20:
+			// Ignored
+		default:
+			if len(t.tok.Data) < MaxStringSize {
+				t.tok.Raw = append(t.tok.Raw, ch)
+				t.tok.Data = append(t.tok.Data, ch)
+			}
+		}
+		return
+
+	case tokStringEscape:
+		if ch == '\\' { // String Terminator
+			t.tok.Raw = append(t.tok.Raw, ESC, ch)
+			return t.emit(fn)
+		}
+		// The string is terminated by the next escape sequence
+		if err = t.emit(fn); err != nil {
+			return
+		}
+		t.start(TokenESC, offset-1, ESC)
+		t.state = tokEscape
+		return t.feed(ch, offset, fn)
+	}
+
+	// Escape and control sequences
+	switch {
+	case ch == ESC:
+		if err = t.abort(fn); err != nil {
+			return
+		}
+		t.start(TokenESC, offset, ch)
+		t.state = tokEscape
+		return
+	case ch == CAN || ch == SUB:
+		return t.cancel(ch, offset, fn)
+	case ch < 0x20:
+		// Controls are executed in the middle of a sequence
+		return fn(&Token{Kind: TokenControl, Offset: offset, Raw: []byte{ch}, Final: ch})
+	case ch == 0x7f:
+		// Ignored
+		return
+	case ch > 0x7f:
+		if err = t.abort(fn); err != nil {
+			return
+		}
+		return t.feed(ch, offset, fn)
+	}
+
+	t.tok.Raw = append(t.tok.Raw, ch)
+	switch t.state {
+	case tokEscape:
+		switch {
+		case ch == '[': // Control Sequence Introducer
+			t.tok.Kind = TokenCSI
+			t.state = tokCSI
+		case ch == ']':
+			t.tok.Kind = TokenOSC
+			t.tok.Final = ch
+			t.state = tokString
+		case ch == 'P':
+			t.tok.Kind = TokenDCS
+			t.tok.Final = ch
+			t.state = tokString
+		case ch == 'X' || ch == '^' || ch == '_':
+			t.tok.Kind = TokenString
+			t.tok.Final = ch
+			t.state = tokString
+		case ch <= 0x2f:
+			t.tok.Intermediate = append(t.tok.Intermediate, ch)
+			t.state = tokIntermediate
+		default:
+			t.tok.Final = ch
+			return t.emit(fn)
+		}
+
+	case tokIntermediate:
+		if ch <= 0x2f {
+			t.tok.Intermediate = append(t.tok.Intermediate, ch)
+		} else {
+			t.tok.Final = ch
+			return t.emit(fn)
+		}
+
+	case tokCSI:
+		t.csi(ch)
+		if ch >= 0x40 {
+			t.tok.Final = ch
+			return t.emit(fn)
+		}
+	}
+	return
+}
+
+// csi parses a parameter or intermediate byte of a control sequence
+func (t *Tokenizer) csi(ch byte) {
+	tok := t.tok
+	switch {
+	case ch <= 0x2f: // Intermediate
+		tok.Intermediate = append(tok.Intermediate, ch)
+		return
+	case ch >= 0x40: // Final
+		return
+	case len(tok.Intermediate) > 0:
+		// Parameters after intermediate bytes
+		t.bad = true
+		return
+	case ch >= '<':
+		if len(tok.Params) > 0 || tok.Private != 0 {
+			// Private markers are only valid at the start
+			t.bad = true
+		} else {
+			tok.Private = ch
+		}
+		return
+	}
+
+	if len(tok.Params) == 0 {
+		tok.Params = []Param{{-1}}
+	}
+	p := &tok.Params[len(tok.Params)-1]
+	switch ch {
+	case ';':
+		if len(tok.Params) < maxParams {
+			tok.Params = append(tok.Params, Param{-1})
+		}
+	case ':':
+		if len(*p) < maxParams {
+			*p = append(*p, -1)
+		}
+	default: // Digit
+		v := &(*p)[len(*p)-1]
+		if *v < 0 {
+			*v = 0
+		}
+		if *v <= (maxParam-9)/10 {
+			*v = *v*10 + int(ch-'0')
+		} else {
+			*v = maxParam
+		}
+	}
+}
+
+// cancel the current sequence with CAN or SUB, the control is passed on
+func (t *Tokenizer) cancel(ch byte, offset int64, fn func(*Token) error) error {
+	if err := t.abort(fn); err != nil {
+		return err
+	}
+	return fn(&Token{Kind: TokenControl, Offset: offset, Raw: []byte{ch}, Final: ch})
+}
@@ -1,6 +1,7 @@
 package binarytext
 
 import (
+	"context"
 	"image"
 	"io"
 	"io/ioutil"
@@ -13,46 +14,105 @@ import (
 )
 
 type BinaryText struct {
-	Palette palette.Palette
-	buffer  *buffer.Buffer
-	font    *font.Font
-	sauce   *sauce.SAUCE
+	Palette  palette.Palette
+	buffer   *buffer.Buffer
+	options  parser.Options
+	sauce    *sauce.SAUCE
+	warnings parser.WarningLog
 }
 
-func New() *BinaryText {
+// DefaultWidth is the width of a binary text without SAUCE record.
+const DefaultWidth = 80
+
+// New initializes a new Binary Text parser, the options may be nil.
+func New(o *parser.Options) *BinaryText {
 	p := &BinaryText{
 		Palette: Palette,
+		options: o.Copy(),
+	}
+	p.warnings.Strict = p.options.Strict
+	if p.options.Palette != nil {
+		p.Palette = p.options.Palette.Copy()
 	}
 	return p
 }
 
+func init() {
+	parser.Register(&parser.Format{
+		Name:       "Binary text (raw VGA page)",
+		Names:      []string{"bin", "binarytext"},
+		Extensions: []string{".bin"},
+		SAUCE: []parser.SAUCEType{
+			{DataType: sauce.DataTypeBinaryText, FileType: parser.AnyFileType},
+		},
+		Score: score,
+		New: func(o *parser.Options, _ *sauce.SAUCE) parser.Parser {
+			return New(o)
+		},
+	})
+}
+
 // Parse the Binary Text buffer
-func (p *BinaryText) Parse(r io.Reader) (err error) {
+func (p *BinaryText) Parse(r io.Reader) error {
+	return p.ParseContext(context.Background(), r)
+}
+
+// ParseContext parses the Binary Text buffer, until the context is done.
+func (p *BinaryText) ParseContext(ctx context.Context, r io.Reader) (err error) {
+	p.warnings.ResetWarnings()
+
 	var b []byte
-	b, err = ioutil.ReadAll(r)
+	b, err = ioutil.ReadAll(parser.ContextReader(ctx, r))
 	if err != nil {
 		return
 	}
 
 	// SAUCE can alter the width
-	var w = 80
-	p.sauce, err = sauce.ParseBytes(b)
-	if err == nil {
+	var (
+		w     = DefaultWidth
+		flags sauce.TFlags
+	)
+	if p.options.Width > 0 {
+		w = p.options.Width
+	}
+	switch p.sauce, err = sauce.ParseBytes(b); err {
+	case nil:
 		b = b[:len(b)-128] // Remove SAUCE record
-		if p.sauce.FileType > 0 {
+		if p.sauce.FileType > 0 && p.options.Width == 0 {
 			w = int(uint16(p.sauce.FileType) << 1)
 		}
+		flags = p.sauce.TFlags
+	case sauce.ErrNoRecord:
+	default:
+		if err = p.warnings.Warn(parser.WarningSAUCE, int64(len(b)), "", "%v", err); err != nil {
+			return
+		}
 	}
 
 	h := int(len(b) / w / 2)
+	if n := len(b) - h*w*2; n > 0 {
+		if err = p.warnings.Warn(parser.WarningTruncated, int64(h*w*2), "", "incomplete row of %d bytes", n); err != nil {
+			return
+		}
+	}
+	if err = p.options.Limits.Check(w, h); err != nil {
+		return
+	}
 	p.buffer = buffer.New(w, h)
+	p.buffer.Limits = p.options.Limits
+	p.buffer.Flags = p.options.Flags(flags)
 	p.buffer.FromMemory(b)
 	return nil
 }
 
-// Font returns the font (always nil, no embedded font support)
+// Buffer returns the internal buffer.
+func (p *BinaryText) Buffer() *buffer.Buffer {
+	return p.buffer
+}
+
+// Font returns the font from the options, there is no embedded font support.
 func (p *BinaryText) Font() *font.Font {
-	return nil
+	return p.options.Font
 }
 
 func (p *BinaryText) Width() int {
@@ -69,6 +129,11 @@ func (p *BinaryText) Image(f *font.Font) (image.Image, error) {
 	return p.buffer.Image(p.Palette, f)
 }
 
+// Render returns the internal buffer as an image, with render options o.
+func (p *BinaryText) Render(f *font.Font, o *buffer.RenderOptions) (image.Image, error) {
+	return p.buffer.Render(p.Palette, f, o)
+}
+
 func (p *BinaryText) HTML(full bool) (string, error) {
 	return "", parser.ErrNotSupported
 }
@@ -80,3 +145,8 @@ func (p *BinaryText) String() string {
 func (p *BinaryText) SAUCE() *sauce.SAUCE {
 	return p.sauce
 }
+
+// Warnings returns the problems found during the last Parse.
+func (p *BinaryText) Warnings() []parser.Warning {
+	return p.warnings.Warnings()
+}
@@ -0,0 +1,43 @@
+package binarytext
+
+// rowSize is the number of bytes in an 80 column row
+const rowSize = 160
+
+// score the likelihood that the sample is a binary text. A binary text
+// interleaves characters and attributes, so the byte values at even and odd
+// offsets have different distributions, where text has the same distribution
+// on both.
+func score(sample []byte, size int64) float64 {
+	if size%2 != 0 {
+		return 0
+	}
+
+	var confidence = 0.05
+	if size%rowSize == 0 {
+		confidence = 0.2
+	}
+	if len(sample) < rowSize {
+		// Too short to tell characters and attributes apart
+		return confidence
+	}
+
+	var even, odd [256]int
+	for i := 0; i+1 < len(sample); i += 2 {
+		even[sample[i]]++
+		odd[sample[i+1]]++
+	}
+
+	// Total variation distance between the distributions
+	var (
+		n        = float64(len(sample) / 2)
+		distance float64
+	)
+	for i := range even {
+		d := float64(even[i]-odd[i]) / n
+		if d < 0 {
+			d = -d
+		}
+		distance += d
+	}
+	return confidence + 0.7*distance/2
+}
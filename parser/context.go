@@ -0,0 +1,24 @@
+package parser
+
+import (
+	"context"
+	"io"
+)
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// ContextReader returns a reader that fails with the context error, once the
+// context is done.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,38 @@
+package irc
+
+import "golang.org/x/text/encoding/charmap"
+
+// cp437 maps the code page 437 glyphs to Unicode. The control characters
+// are mapped to the glyphs a VGA card displays for them.
+var cp437 [256]rune
+
+// fromUnicode maps the Unicode characters outside of ASCII to code page 437
+var fromUnicode = make(map[rune]byte)
+
+func init() {
+	for i := range cp437 {
+		cp437[i] = charmap.CodePage437.DecodeByte(byte(i))
+	}
+	for i, r := range []rune(" ☺☻♥♦♣♠•◘○◙♂♀♪♫☼►◄↕‼¶§▬↨↑↓→←∟↔▲▼") {
+		cp437[i] = r
+	}
+	cp437[0x7f] = '⌂'
+	for i, r := range cp437 {
+		if r >= 0x80 {
+			fromUnicode[r] = byte(i)
+		}
+	}
+}
+
+// toCP437 returns the code page 437 character for rune r, including the
+// glyphs of the control characters. Runes that have no code page 437
+// equivalent are replaced by a question mark.
+func toCP437(r rune) byte {
+	if r < 0x80 {
+		return byte(r)
+	}
+	if c, ok := fromUnicode[r]; ok {
+		return c
+	}
+	return '?'
+}
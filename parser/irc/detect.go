@@ -0,0 +1,52 @@
+package irc
+
+import "bytes"
+
+// score the likelihood that the sample is an IRC log. Formatting codes and
+// time stamped lines are evidence, escape sequences and NUL bytes are not
+// expected in IRC logs.
+func score(sample []byte, size int64) float64 {
+	if len(sample) == 0 || bytes.IndexByte(sample, 0x00) >= 0 || bytes.Contains(sample, []byte{0x1b, '['}) {
+		return 0
+	}
+
+	var codes int
+	for i, c := range sample {
+		switch c {
+		case Color:
+			if i+1 < len(sample) && isDigit(sample[i+1]) {
+				codes++
+			}
+		case Bold, HexColor, Reset, Monospace, Reverse, Italics, Strikethrough, Underline:
+			codes++
+		}
+	}
+
+	var confidence float64
+	if codes > 0 {
+		// Saturates at one code per 40 bytes
+		density := float64(codes*40) / float64(len(sample))
+		if density > 1 {
+			density = 1
+		}
+		confidence = 0.5 + 0.3*density
+	}
+
+	var lines, stamped int
+	for _, line := range bytes.Split(sample, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		if weechatLine.Match(line) || stampLine.Match(line) {
+			stamped++
+		}
+	}
+	if lines > 0 {
+		confidence += 0.6 * float64(stamped) / float64(lines)
+	}
+	if confidence > 1 {
+		confidence = 1
+	}
+	return confidence
+}
@@ -0,0 +1,252 @@
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"unicode/utf8"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/buffer/attribute"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// DefaultLimit is the default maximum number of bytes per line. IRC messages
+// are limited to 512 bytes, including the command, the target, the prefix
+// the server adds for the receiving clients and the trailing CR LF.
+const DefaultLimit = 350
+
+// Encoder converts a buffer to lines with mIRC formatting codes.
+type Encoder struct {
+	// Limit is the maximum number of bytes per line, longer lines are split
+	// and the formatting is repeated on the continuation line.
+	Limit int
+
+	// Extended enables the use of mIRC colors 16-98, not all clients support
+	// the extended colors.
+	Extended bool
+
+	// Raw writes the characters as-is, in stead of converting code page 437
+	// to UTF-8. Use for buffers that contain UTF-8 text.
+	Raw bool
+
+	w io.Writer
+}
+
+// format is the formatting state of the encoder
+type format struct {
+	fg, bg int
+	attr   uint32
+}
+
+// attributes that have a mIRC formatting code
+var attributeCodes = []struct {
+	attr uint32
+	code byte
+}{
+	{attribute.Bold, Bold},
+	{attribute.Italics, Italics},
+	{attribute.Underline, Underline},
+	{attribute.CrossedOut, Strikethrough},
+}
+
+// NewEncoder returns an encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		Limit: DefaultLimit,
+		w:     w,
+	}
+}
+
+// Encode the buffer, using palette p for the buffer colors.
+func (e *Encoder) Encode(b *buffer.Buffer, p palette.Palette) error {
+	colors := e.colorMap(p)
+
+	w, h := b.SizeMax()
+	for y := 0; y < h; y++ {
+		// Strip undrawn tiles from the end of the line
+		l := w
+		for l > 0 && tileAt(b, l-1, y) == nil {
+			l--
+		}
+
+		var (
+			line  []byte
+			state = format{-1, -1, 0}
+		)
+		for x := 0; x < l; x++ {
+			t := tileAt(b, x, y)
+			next := formatFor(b, t, colors)
+			char := e.char(t)
+			blank := char[0] == ' '
+
+			code := state.to(next, blank, char[0])
+			if e.Limit > 0 && len(line) > 0 && len(line)+len(code)+len(char) > e.Limit && !e.continuation(t) {
+				// Continue on a new line, formatting doesn't carry over
+				if err := e.writeLine(line); err != nil {
+					return err
+				}
+				line = line[:0]
+				state = format{-1, -1, 0}
+				code = state.to(next, blank, char[0])
+			}
+			line = append(line, code...)
+			line = append(line, char...)
+			state.update(next, blank)
+		}
+
+		if len(line) == 0 {
+			// Empty messages are not relayed
+			line = append(line, ' ')
+		}
+		if err := e.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) writeLine(line []byte) error {
+	_, err := e.w.Write(append(line, '\n'))
+	return err
+}
+
+// char returns the encoded character for a tile.
+func (e *Encoder) char(t *buffer.Tile) []byte {
+	if t == nil {
+		return []byte{' '}
+	}
+	if e.Raw {
+		if t.Char < 0x20 || t.Char == 0x7f {
+			// Don't emit control characters that may be formatting codes
+			return []byte{' '}
+		}
+		return []byte{t.Char}
+	}
+	var b = make([]byte, utf8.UTFMax)
+	return b[:utf8.EncodeRune(b, cp437[t.Char])]
+}
+
+// continuation checks if the tile holds a UTF-8 continuation byte, lines are
+// not split in the middle of a character.
+func (e *Encoder) continuation(t *buffer.Tile) bool {
+	return e.Raw && t != nil && t.Char&0xc0 == 0x80
+}
+
+// colorMap maps the palette to the nearest mIRC colors.
+func (e *Encoder) colorMap(p palette.Palette) []int {
+	n := 16
+	if e.Extended {
+		n = len(Palette)
+	}
+
+	m := make([]int, len(p))
+	for i, c := range p {
+		m[i] = nearest(c, Palette[:n])
+	}
+	return m
+}
+
+// nearest returns the index of the color in p closest to c.
+func nearest(c color.Color, p palette.Palette) (n int) {
+	var best = -1
+	r0, g0, b0, _ := c.RGBA()
+	for i, o := range p {
+		r1, g1, b1, _ := o.RGBA()
+		dr := int(r0>>8) - int(r1>>8)
+		dg := int(g0>>8) - int(g1>>8)
+		db := int(b0>>8) - int(b1>>8)
+		if d := dr*dr + dg*dg + db*db; best < 0 || d < best {
+			best, n = d, i
+		}
+	}
+	return
+}
+
+// tileAt returns the tile at x, y, or nil if it was never drawn.
+func tileAt(b *buffer.Buffer, x, y int) *buffer.Tile {
+	o := (y * b.Width) + x
+	if o >= len(b.Tiles) {
+		return nil
+	}
+	return b.Tiles[o]
+}
+
+// formatFor returns the mIRC formatting for a tile.
+func formatFor(b *buffer.Buffer, t *buffer.Tile, colors []int) (f format) {
+	if t == nil {
+		t = buffer.NewTile()
+	}
+	fg, bg := b.TileColors(t)
+	f.fg = colorAt(colors, fg)
+	f.bg = colorAt(colors, bg)
+	for _, a := range attributeCodes {
+		if a.attr == attribute.Bold && !b.BoldFont {
+			// Bold is a bright foreground color
+			continue
+		}
+		f.attr |= t.Attributes & a.attr
+	}
+	return
+}
+
+func colorAt(colors []int, i int) int {
+	if i < 0 || i >= len(colors) {
+		return DefaultColor
+	}
+	return colors[i]
+}
+
+// to returns the codes to switch from format f to the next format. The
+// foreground color of a blank character is irrelevant, so it is only changed
+// if the foreground matters. The peek byte is the next character, it is used
+// to determine if the color numbers need to be padded.
+func (f format) to(next format, blank bool, peek byte) []byte {
+	var b bytes.Buffer
+
+	for _, a := range attributeCodes {
+		if (f.attr^next.attr)&a.attr != 0 {
+			b.WriteByte(a.code)
+		}
+	}
+
+	fg := next.fg
+	if blank && next.attr&(attribute.Underline|attribute.CrossedOut) == 0 && f.fg >= 0 {
+		fg = f.fg
+	}
+
+	switch {
+	case next.bg != f.bg:
+		b.WriteByte(Color)
+		b.WriteString(fmt.Sprintf("%d,%s", fg, colorNumber(next.bg, peek)))
+	case fg != f.fg:
+		b.WriteByte(Color)
+		if peek == ',' {
+			// A comma after the foreground color would be a separator
+			b.WriteString(fmt.Sprintf("%d,%s", fg, colorNumber(next.bg, peek)))
+		} else {
+			b.WriteString(colorNumber(fg, peek))
+		}
+	}
+	return b.Bytes()
+}
+
+// update the state after writing a character with format next.
+func (f *format) update(next format, blank bool) {
+	if !blank || next.attr&(attribute.Underline|attribute.CrossedOut) != 0 || f.fg < 0 {
+		f.fg = next.fg
+	}
+	f.bg = next.bg
+	f.attr = next.attr
+}
+
+// colorNumber formats a color number, numbers are padded to two digits if
+// the next character is a digit.
+func colorNumber(c int, peek byte) string {
+	if isDigit(peek) {
+		return fmt.Sprintf("%02d", c)
+	}
+	return fmt.Sprintf("%d", c)
+}
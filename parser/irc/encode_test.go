@@ -0,0 +1,83 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/palette"
+)
+
+func TestEncode(t *testing.T) {
+	b := buffer.New(80, 1)
+	b.Cursor.Color = 4 // CGA blue
+	for _, c := range []byte("\xdb1 ") {
+		b.PutChar(c)
+	}
+	b.Cursor.Background = 4
+	b.PutChar(' ')
+	b.Cursor.Color = 15
+	b.PutChar('x')
+
+	var out bytes.Buffer
+	if err := NewEncoder(&out).Encode(b, palette.CGA); err != nil {
+		t.Fatal(err)
+	}
+	if s, e := out.String(), "\x032,1█1 \x032,2 \x030x\n"; s != e {
+		t.Fatalf("expected %q, got %q", e, s)
+	}
+}
+
+func TestEncodeLimit(t *testing.T) {
+	b := buffer.New(200, 1)
+	for i := 0; i < 200; i++ {
+		b.Cursor.Color = i % 16
+		b.Cursor.Background = (i / 16) % 8
+		b.PutChar('A' + byte(i%26))
+	}
+
+	var out bytes.Buffer
+	e := NewEncoder(&out)
+	e.Limit = 100
+	if err := e.Encode(b, palette.CGA); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected the line to be split, got %q", lines)
+	}
+	for _, line := range lines {
+		if len(line) > e.Limit {
+			t.Fatalf("line exceeds limit of %d bytes: %d", e.Limit, len(line))
+		}
+	}
+
+	// The output must render the same characters and colors
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(strings.Join(lines, ""))); err != nil {
+		t.Fatal(err)
+	}
+	colors := e.colorMap(palette.CGA)
+	for i := 0; i < 200; i++ {
+		o, c := b.Tiles[i], p.buffer.TileAt(i, 0)
+		if c.Char != o.Char || c.Color != colors[o.Color] || c.Background != colors[o.Background] {
+			t.Fatalf("tile %d: expected %s, got %s", i, o, c)
+		}
+	}
+}
+
+func TestEncodeUTF8(t *testing.T) {
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("café ☺")); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := NewEncoder(&out).Encode(p.Buffer(), p.Palette); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.HasSuffix(s, "café ☺\n") {
+		t.Fatalf("expected the text to survive a round trip, got %q", s)
+	}
+}
@@ -0,0 +1,18 @@
+package irc
+
+import "git.maze.io/maze/go-piece/buffer"
+
+// HTML returns the internal buffer as HTML.
+func (p *IRC) HTML(full bool) (string, error) {
+	return p.buffer.HTML(p.Palette, full, &buffer.HTMLOptions{
+		Head:       "<meta charset=\"utf-8\">\n",
+		Color:      DefaultColor,
+		Background: DefaultBackground,
+		Char:       htmlChar,
+	}), nil
+}
+
+// htmlChar converts a code page 437 character to UTF-8.
+func htmlChar(c byte) string {
+	return string(cp437[c])
+}
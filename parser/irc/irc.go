@@ -1,10 +1,18 @@
+// Package irc is a parser for IRC logs with mIRC formatting codes.
 package irc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
 	"image"
+	"image/color"
 	"io"
+	"io/ioutil"
 	"strconv"
+	"time"
+	"unicode/utf8"
 
 	"git.maze.io/maze/go-piece/buffer"
 	"git.maze.io/maze/go-piece/buffer/attribute"
@@ -14,145 +22,347 @@ import (
 	sauce "git.maze.io/maze/go-sauce"
 )
 
+// IRC log parser
 type IRC struct {
+	Palette palette.Palette
+
+	// Proportional sizes the canvas to fit the longest line, if not set lines
+	// are wrapped at the option width, or 80 columns.
+	Proportional bool
+
+	// Date for log lines that only have a time stamp, until the log records
+	// a date. Useful for logs that have one file per day, such as ZNC logs.
+	Date time.Time
+
 	buffer  *buffer.Buffer
-	palette palette.Palette
+	lines   []*Line
+	options parser.Options
 }
 
+// mIRC formatting codes, see https://modern.ircdocs.horse/formatting.html
 const (
-	stateExit = iota
-	stateText
-	stateColor
-	stateBackground
+	Bold          byte = 0x02
+	Color         byte = 0x03
+	HexColor      byte = 0x04
+	Reset         byte = 0x0f
+	Monospace     byte = 0x11
+	Reverse       byte = 0x16
+	Italics       byte = 0x1d
+	Strikethrough byte = 0x1e
+	Underline     byte = 0x1f
 )
 
+// Default colors, black on white like mIRC. The background is the first
+// palette color, so it matches the canvas next to short lines.
 const (
-	bold       byte = 0x0b
-	colored    byte = 0x0c
-	italics    byte = 0x1d
-	underlined byte = 0x1f
-	reversed   byte = 0x16
-	reset      byte = 0x0f
+	DefaultColor      = 1
+	DefaultBackground = 0
 )
 
-// colorMap is a mapping between mIRC colors and CGA colors
-var colorMap = []int{
-	0x0f, /* white */
-	0x00, /* black */
-	0x01, /* blue */
-	0x03, /* green */
-	0x09, /* red */
-	0x01, /* maroon */
-	0x05, /* purple */
-	0x03, /* orange */
-	0x0b, /* yellow */
-	0x0a, /* light green */
-	0x06, /* teal */
-	0x0e, /* cyan */
-	0x0d, /* fuchsia */
-	0x08, /* dark grey */
-	0x07, /* light grey */
-}
-
-func New() *IRC {
-	return &IRC{
-		buffer:  buffer.New(80, 1),
-		palette: palette.CGA,
-	}
-}
-
-func (p *IRC) Parse(r io.Reader) (err error) {
-	state := stateText
-	buf := bufio.NewReader(r)
-
-	var fg, bg []byte
-	for state != stateExit {
-		var ch byte
-		if ch, err = buf.ReadByte(); err != nil {
-			if err == io.EOF {
-				err = nil
+// defaultWidth is the number of columns if the canvas is not proportional
+const defaultWidth = 80
+
+// colorDefault selects the default foreground or background color
+const colorDefault = 99
+
+// New initializes a new IRC log parser, the options may be nil. The canvas is
+// proportional, unless the options set a width. The input is assumed to be
+// UTF-8 encoded, input in the encoding of the options is converted to UTF-8.
+func New(o *parser.Options) *IRC {
+	p := &IRC{
+		Palette: Palette,
+		options: o.Copy(),
+	}
+	if p.options.Palette != nil {
+		p.Palette = p.options.Palette.Copy()
+	}
+	p.Proportional = p.options.Width <= 0
+	p.init(p.width())
+	return p
+}
+
+func init() {
+	parser.Register(&parser.Format{
+		Name:       "IRC log with mIRC formatting",
+		Names:      []string{"irc", "mirc"},
+		Extensions: []string{".irc", ".log"},
+		Score:      score,
+		New: func(o *parser.Options, _ *sauce.SAUCE) parser.Parser {
+			return New(o)
+		},
+	})
+}
+
+// init allocates a w column buffer
+func (p *IRC) init(w int) {
+	p.buffer = buffer.New(w, 1)
+	p.buffer.BoldFont = true
+	p.buffer.Flags = p.options.Flags(p.buffer.Flags)
+	p.buffer.Limits = p.options.Limits
+	// The tiles of the lines are checked before parsing, the tiles after the
+	// end of a line don't count
+	p.buffer.Limits.Tiles = 0
+	p.reset()
+}
+
+// width returns the number of columns of a non-proportional canvas
+func (p *IRC) width() int {
+	if p.options.Width > 0 {
+		return p.options.Width
+	}
+	return defaultWidth
+}
+
+// Parse the IRC log from a reader
+func (p *IRC) Parse(r io.Reader) error {
+	return p.ParseContext(context.Background(), r)
+}
+
+// ParseContext parses the IRC log from a reader, until the context is done.
+func (p *IRC) ParseContext(ctx context.Context, r io.Reader) (err error) {
+	var b []byte
+	if b, err = ioutil.ReadAll(parser.ContextReader(ctx, r)); err != nil {
+		return
+	}
+	if p.options.Encoding != nil {
+		if b, err = p.options.Encoding.NewDecoder().Bytes(b); err != nil {
+			return
+		}
+	}
+
+	raw := bytes.Split(b, []byte{'\n'})
+	if n := len(raw); n > 1 && len(raw[n-1]) == 0 {
+		// Trailing newline
+		raw = raw[:n-1]
+	}
+	var w int
+	if w, err = p.size(raw); err != nil {
+		return
+	}
+	p.init(w)
+
+	p.lines = p.lines[:0]
+	date := p.Date
+	for _, line := range raw {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		l := &Line{
+			Raw: bytes.TrimSuffix(line, []byte{'\r'}),
+			Row: p.buffer.Cursor.Y,
+		}
+		var text string
+		if text, err = p.parseLine(l.Raw); err != nil {
+			return
+		}
+		if l.Rows = p.buffer.Cursor.Y - l.Row; p.buffer.Cursor.X > 0 || l.Rows == 0 {
+			l.Rows++
+		}
+		l.parse(text, &date)
+		p.lines = append(p.lines, l)
+
+		// Formatting doesn't carry over to the next message
+		p.buffer.Cursor.X = 0
+		p.buffer.Cursor.Y = l.Row + l.Rows
+		p.reset()
+	}
+
+	return
+}
+
+// size returns the number of columns of the canvas for the lines, after
+// checking the limits. Only the tiles the lines use count towards the tile
+// limit, not the tiles after the end of a line.
+func (p *IRC) size(lines [][]byte) (w int, err error) {
+	var (
+		columns = make([]int, len(lines))
+		h, used int
+	)
+	w = 1
+	for i, line := range lines {
+		columns[i] = p.columns(line)
+		if columns[i] > w {
+			w = columns[i]
+		}
+	}
+	if !p.Proportional {
+		w = p.width()
+	}
+	for _, n := range columns {
+		// Lines wrap at the end of the canvas, an empty line uses a row
+		h += (n + w - 1) / w
+		if n == 0 {
+			h++
+		}
+		used += n
+	}
+
+	l := p.options.Limits
+	if err = l.Check(w, 1); err != nil {
+		return
+	}
+	if err = l.Check(1, h); err != nil {
+		return
+	}
+	return w, l.CheckTiles(used)
+}
+
+// columns returns the number of columns of a line, without formatting codes.
+func (p *IRC) columns(line []byte) (n int) {
+	buf := bufio.NewReader(bytes.NewReader(line))
+	for {
+		ch, err := buf.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch ch {
+		case '\r', Bold, Italics, Underline, Strikethrough, Reverse, Monospace, Reset:
+		case '\t':
+			n += p.options.Tab() - n%p.options.Tab()
+		case Color:
+			if readDigits(buf, 2) != "" && peekSeparator(buf, 1, isDigit) {
+				readDigits(buf, 2)
 			}
-			state = stateExit
-			continue
-		}
-
-		switch state {
-		case stateText:
-			switch ch {
-			case '\r':
-			case '\n':
-				p.buffer.Cursor.X = 0
-				p.buffer.Cursor.Y++
-				p.buffer.Cursor.ResetAttributes()
-			case bold:
-				p.buffer.Cursor.Attributes |= attribute.Bold
-			case italics:
-				p.buffer.Cursor.Attributes |= attribute.Italics
-			case underlined:
-				p.buffer.Cursor.Attributes |= attribute.Underline
-			case reset:
-				p.buffer.Cursor.ResetAttributes()
-			case reversed:
-				p.buffer.Cursor.Color, p.buffer.Cursor.Background = p.buffer.Cursor.Background, p.buffer.Cursor.Color
-			case colored:
-				state = stateColor
-				fg = []byte{}
-				bg = []byte{}
-			default:
-				p.buffer.PutChar(ch)
+		case HexColor:
+			if _, ok := readHex(buf); ok && peekSeparator(buf, 6, isHex) {
+				readHex(buf)
 			}
-
-		case stateColor:
-			switch {
-			case ch == ',':
-				state = stateBackground
-
-			case ch >= '0' && ch <= '9':
-				fg = append(fg, ch)
-				if len(fg) == 2 { // Double digits
-					c, _ := strconv.Atoi(string(fg))
-					p.buffer.Cursor.Color = colorMap[c%16]
-					fg = []byte{}
-				}
-
-			default:
-				if len(fg) > 0 {
-					c, _ := strconv.Atoi(string(fg))
-					p.buffer.Cursor.Color = colorMap[c%16]
-					fg = []byte{}
-				}
-				state = stateText
+		default:
+			if ch >= utf8.RuneSelf {
+				buf.UnreadByte()
+				buf.ReadRune()
 			}
+			n++
+		}
+	}
+}
 
-		case stateBackground:
-			if ch >= '0' && ch <= '9' {
-				bg = append(bg, ch)
-				if len(bg) == 2 { // Double digits
-					c, _ := strconv.Atoi(string(fg))
-					p.buffer.Cursor.Background = colorMap[c%16]
-					bg = []byte{}
-					state = stateText
-				}
-			} else {
-				if len(bg) > 0 {
-					c, _ := strconv.Atoi(string(fg))
-					p.buffer.Cursor.Background = colorMap[c%16]
-					bg = []byte{}
-				}
-				state = stateText
+// parseLine parses the formatting codes in a single line and returns the
+// line without formatting. The UTF-8 encoded text is stored as code page 437.
+func (p *IRC) parseLine(line []byte) (string, error) {
+	var (
+		text []byte
+		buf  = bufio.NewReader(bytes.NewReader(line))
+	)
+
+	for {
+		ch, err := buf.ReadByte()
+		if err != nil {
+			return string(text), nil
+		}
+
+		switch ch {
+		case '\r':
+		case '\t':
+			tabStop := p.options.Tab()
+			for n := tabStop - p.buffer.Cursor.X%tabStop; n > 0 && err == nil; n-- {
+				err = p.buffer.PutChar(' ')
+			}
+			text = append(text, ch)
+		case Bold:
+			p.buffer.Cursor.Attributes ^= attribute.Bold
+		case Italics:
+			p.buffer.Cursor.Attributes ^= attribute.Italics
+		case Underline:
+			p.buffer.Cursor.Attributes ^= attribute.Underline
+		case Strikethrough:
+			p.buffer.Cursor.Attributes ^= attribute.CrossedOut
+		case Reverse:
+			p.buffer.Cursor.Attributes ^= attribute.Negative
+		case Monospace:
+			// All text is rendered in a monospace font
+		case Reset:
+			p.reset()
+		case Color:
+			p.parseColor(buf)
+		case HexColor:
+			err = p.parseHexColor(buf)
+		default:
+			r := rune(ch)
+			if ch >= utf8.RuneSelf {
+				buf.UnreadByte()
+				r, _, _ = buf.ReadRune()
 			}
+			err = p.buffer.PutChar(toCP437(r))
+			text = append(text, string(r)...)
+		}
+		if err != nil {
+			return "", err
 		}
 	}
+}
+
+// parseColor parses a <CODE>[<fg>[,<bg>]] color sequence, where fg and bg
+// are one or two digit mIRC color numbers.
+func (p *IRC) parseColor(r *bufio.Reader) {
+	fg := readDigits(r, 2)
+	if fg == "" {
+		// No color number, the sequence resets the colors
+		p.buffer.Cursor.Color = DefaultColor
+		p.buffer.Cursor.Background = DefaultBackground
+		return
+	}
+	p.buffer.Cursor.Color = colorIndex(fg, DefaultColor)
+
+	if peekSeparator(r, 1, isDigit) {
+		bg := readDigits(r, 2)
+		p.buffer.Cursor.Background = colorIndex(bg, DefaultBackground)
+	}
+}
 
+// parseHexColor parses a <CODE>[<fg>[,<bg>]] color sequence, where fg and bg
+// are RRGGBB hexadecimal colors.
+func (p *IRC) parseHexColor(r *bufio.Reader) (err error) {
+	fg, ok := readHex(r)
+	if !ok {
+		p.buffer.Cursor.Color = DefaultColor
+		p.buffer.Cursor.Background = DefaultBackground
+		return
+	}
+	if p.buffer.Cursor.Color, err = p.addRGB(fg); err != nil {
+		return
+	}
+
+	if peekSeparator(r, 6, isHex) {
+		if bg, ok := readHex(r); ok {
+			p.buffer.Cursor.Background, err = p.addRGB(bg)
+		}
+	}
 	return
 }
 
-func (p *IRC) HTML(full bool) (string, error) {
-	return "", parser.ErrNotSupported
+// reset the cursor to the default formatting
+func (p *IRC) reset() {
+	p.buffer.Cursor.ResetAttributes()
+	p.buffer.Cursor.Color = DefaultColor
+	p.buffer.Cursor.Background = DefaultBackground
+}
+
+// addRGB returns the palette index of color c, the color is added to the
+// palette if it isn't present yet.
+func (p *IRC) addRGB(c color.RGBA) (int, error) {
+	for i, o := range p.Palette {
+		if o == c {
+			return i, nil
+		}
+	}
+	if err := p.options.Limits.CheckColors(len(p.Palette) + 1); err != nil {
+		return 0, err
+	}
+	p.Palette = append(p.Palette, c)
+	return len(p.Palette) - 1, nil
+}
+
+// Image returns the internal buffer as an image.
+func (p *IRC) Image(f *font.Font) (image.Image, error) {
+	return p.buffer.Image(p.Palette, f)
 }
 
-func (p *IRC) Image(font *font.Font) (image.Image, error) {
-	return nil, parser.ErrNotSupported
+// Render returns the internal buffer as an image, with render options o.
+func (p *IRC) Render(f *font.Font, o *buffer.RenderOptions) (image.Image, error) {
+	return p.buffer.Render(p.Palette, f, o)
 }
 
 func (p *IRC) String() (s string) {
@@ -164,7 +374,7 @@ func (p *IRC) String() (s string) {
 			if t == nil {
 				s += " "
 			} else {
-				s += string(t.Char)
+				s += string(cp437[t.Char])
 			}
 		}
 		s += "\n"
@@ -172,9 +382,85 @@ func (p *IRC) String() (s string) {
 	return
 }
 
-func (p *IRC) Font() *font.Font    { return nil }
+func (p *IRC) Buffer() *buffer.Buffer { return p.buffer }
+
+// Font returns the font from the options, as an IRC log has no font data.
+func (p *IRC) Font() *font.Font { return p.options.Font }
+
 func (p *IRC) Width() int          { return p.buffer.Width }
 func (p *IRC) Height() int         { return p.buffer.Height }
 func (p *IRC) SAUCE() *sauce.SAUCE { return nil }
 
+// Warnings returns nil, mIRC formatting codes are never malformed.
+func (p *IRC) Warnings() []parser.Warning { return nil }
+
 var _ parser.Parser = (*IRC)(nil)
+
+// colorIndex converts a mIRC color number to a palette index, color 99
+// selects the default color d.
+func colorIndex(s string, d int) int {
+	c, _ := strconv.Atoi(s)
+	if c == colorDefault {
+		return d
+	}
+	return c
+}
+
+// peekSeparator checks if the next byte is a comma followed by n bytes
+// accepted by fn, if so the comma is consumed. A comma that isn't followed
+// by a color is part of the text.
+func peekSeparator(r *bufio.Reader, n int, fn func(byte) bool) bool {
+	b, err := r.Peek(1 + n)
+	if err != nil || b[0] != ',' {
+		return false
+	}
+	for _, c := range b[1:] {
+		if !fn(c) {
+			return false
+		}
+	}
+	r.ReadByte()
+	return true
+}
+
+// readDigits reads at most n decimal digits.
+func readDigits(r *bufio.Reader, n int) string {
+	var s []byte
+	for len(s) < n {
+		b, err := r.Peek(1)
+		if err != nil || !isDigit(b[0]) {
+			break
+		}
+		r.ReadByte()
+		s = append(s, b[0])
+	}
+	return string(s)
+}
+
+// readHex reads a RRGGBB hexadecimal color, nothing is consumed if the
+// next six bytes are not all hexadecimal digits.
+func readHex(r *bufio.Reader) (c color.RGBA, ok bool) {
+	b, err := r.Peek(6)
+	if err != nil {
+		return
+	}
+	for _, ch := range b {
+		if !isHex(ch) {
+			return
+		}
+	}
+	var rgb = make([]byte, 3)
+	if _, err = hex.Decode(rgb, b); err != nil {
+		return
+	}
+	r.Discard(6)
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}, true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,189 @@
+package irc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/parser"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestParseColor(t *testing.T) {
+	var tests = []struct {
+		Text   string
+		Char   byte
+		Fg, Bg int
+	}{
+		{"\x034x", 'x', 4, DefaultBackground},
+		{"\x0304,12x", 'x', 4, 12},
+		{"\x0352,88x", 'x', 52, 88},
+		{"\x034,x", ',', 4, DefaultBackground},
+		{"\x03,4x", ',', DefaultColor, DefaultBackground},
+		{"\x034,2\x03x", 'x', DefaultColor, DefaultBackground},
+		{"\x0399,99x", 'x', DefaultColor, DefaultBackground},
+		{"\x03123", '3', 12, DefaultBackground},
+	}
+
+	for _, test := range tests {
+		p := New(nil)
+		if err := p.Parse(strings.NewReader(test.Text)); err != nil {
+			t.Fatal(err)
+		}
+		c := p.buffer.TileAt(0, 0)
+		if c.Char != test.Char || c.Color != test.Fg || c.Background != test.Bg {
+			t.Fatalf("%q: expected %q %d,%d, got %q %d,%d", test.Text,
+				test.Char, test.Fg, test.Bg, c.Char, c.Color, c.Background)
+		}
+	}
+}
+
+func TestDefaultColors(t *testing.T) {
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("x\nlonger")); err != nil {
+		t.Fatal(err)
+	}
+	if c := p.buffer.TileAt(0, 0); c.Color != 1 || c.Background != 0 {
+		t.Fatalf("expected black on white, got %d,%d", c.Color, c.Background)
+	}
+	i, err := p.Image(font.Get("cp437", image.Pt(8, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The background of the text matches the canvas after the short line
+	if a, b := i.At(0, 0), i.At(5*8, 0); a != Palette[0] || b != Palette[0] {
+		t.Fatalf("expected a white background, got %v and %v", a, b)
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("\x04ff8000,000000x")); err != nil {
+		t.Fatal(err)
+	}
+	c := p.buffer.TileAt(0, 0)
+	if c.Char != 'x' {
+		t.Fatalf("expected 'x', got %q", c.Char)
+	}
+	if p.Palette[c.Color] != (color.RGBA{0xff, 0x80, 0x00, 0xff}) {
+		t.Fatalf("unexpected foreground %v", p.Palette[c.Color])
+	}
+	if c.Background != 1 {
+		t.Fatalf("expected background to match palette black, got %d", c.Background)
+	}
+	if len(Palette) != 99 {
+		t.Fatalf("package palette was modified")
+	}
+}
+
+func TestParseHexColorSeparator(t *testing.T) {
+	p := New(nil)
+	if err := p.Parse(strings.NewReader("\x04ff0000,abc")); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimRight(p.String(), "\n"); s != ",abc" {
+		t.Fatalf("expected the text \",abc\", got %q", s)
+	}
+}
+
+func TestProportional(t *testing.T) {
+	var text = "\x02bold\x02 " + strings.Repeat("\x0304x", 100) + "\nshort\n"
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	i, err := p.Image(font.Get("cp437", image.Pt(8, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := i.Bounds().Size(); s.X != 105*8 || s.Y != 2*16 {
+		t.Fatalf("expected 840x32 image, got %dx%d", s.X, s.Y)
+	}
+
+	p.Proportional = false
+	if err := p.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	if i, err = p.Image(font.Get("cp437", image.Pt(8, 16))); err != nil {
+		t.Fatal(err)
+	}
+	if s := i.Bounds().Size(); s.X != 80*8 || s.Y != 3*16 {
+		t.Fatalf("expected 640x48 image, got %dx%d", s.X, s.Y)
+	}
+}
+
+func TestLongLog(t *testing.T) {
+	var log strings.Builder
+	for i := 0; i < 8000; i++ {
+		log.WriteString("<nick> hello world\n")
+	}
+	log.WriteString("\x02<nick>\x02 \x0304,12" + strings.Repeat("é", 293) + "\n")
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(log.String())); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := p.Width(), len(p.Lines()); w != 300 || h != 8001 {
+		t.Fatalf("expected 300 columns and 8001 lines, got %d and %d", w, h)
+	}
+
+	// Only the tiles of the lines count
+	p = New(&parser.Options{Limits: buffer.Limits{Tiles: 8000 * 18}})
+	if err := p.Parse(strings.NewReader(log.String())); !errors.Is(err, parser.ErrLimitExceeded) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
+
+func TestParseUTF8(t *testing.T) {
+	p := New(&parser.Options{TabStop: 4})
+	if err := p.Parse(strings.NewReader("\x02h\x02é█\tx")); err != nil {
+		t.Fatal(err)
+	}
+	// The UTF-8 encoded text is stored as code page 437
+	for x, c := range []byte("h\x82\xdb x") {
+		if tile := p.buffer.TileAt(x, 0); tile.Char != c {
+			t.Fatalf("tile %d: expected %#02x, got %#02x", x, c, tile.Char)
+		}
+	}
+	if l := p.Lines()[0]; l.Text != "hé█\tx" {
+		t.Fatalf("unexpected text %q", l.Text)
+	}
+
+	p = New(nil)
+	if err := p.Parse(strings.NewReader("café ☺")); err != nil {
+		t.Fatal(err)
+	}
+	if w := p.Width(); w != 6 {
+		t.Fatalf("expected 6 columns, got %d", w)
+	}
+	if s := strings.TrimRight(p.String(), "\n"); s != "café ☺" {
+		t.Fatalf("expected \"café ☺\", got %q", s)
+	}
+	if html, _ := p.HTML(false); !strings.Contains(html, "café ☺") {
+		t.Fatalf("expected \"café ☺\" in %q", html)
+	}
+
+	p = New(&parser.Options{Encoding: charmap.ISO8859_1})
+	if err := p.Parse(strings.NewReader("caf\xe9")); err != nil {
+		t.Fatal(err)
+	}
+	if l := p.Lines()[0]; l.Text != "café" {
+		t.Fatalf("expected the text to be converted to UTF-8, got %q", l.Text)
+	}
+}
+
+func TestFont(t *testing.T) {
+	f := font.Get("cp437", image.Pt(8, 8))
+	if got := New(&parser.Options{Font: f}).Font(); got != f {
+		t.Fatalf("expected the font of the options, got %v", got)
+	}
+	if got := New(nil).Font(); got != nil {
+		t.Fatalf("expected no font, got %v", got)
+	}
+}
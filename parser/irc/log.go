@@ -0,0 +1,216 @@
+package irc
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LineKind is the type of a log line.
+type LineKind int
+
+// Log line types
+const (
+	LineText    LineKind = iota // Unrecognised line
+	LineMessage                 // <nick> message
+	LineAction                  // * nick action
+	LineNotice                  // -nick- notice
+	LineJoin                    // nick has joined
+	LinePart                    // nick has left
+	LineQuit                    // nick has quit
+)
+
+// Line is a structured log line.
+type Line struct {
+	// Kind of line
+	Kind LineKind
+
+	// Time stamp of the line, is zero if the line has no time stamp. Log
+	// formats that only record the time of day use the date of the last
+	// "Log opened", "Day changed" or "Session Start" line, or the parser Date.
+	Time time.Time
+
+	// Nick that sent the message or triggered the event
+	Nick string
+
+	// Text is the message (or channel, or reason) without formatting codes
+	Text string
+
+	// Raw line, including formatting codes
+	Raw []byte
+
+	// Row is the first buffer row of the line, a line spans Rows rows
+	Row, Rows int
+}
+
+var (
+	// WeeChat: 2006-01-02 15:04:05<TAB>prefix<TAB>message
+	weechatLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\t([^\t]*)\t(.*)$`)
+
+	// ZNC and mIRC: [15:04:05] line, irssi: 15:04 line
+	stampLine = regexp.MustCompile(`^(?:\[((?:\d{4}-\d{2}-\d{2}[ T])?\d{1,2}:\d{2}(?::\d{2})?)\]|(\d{2}:\d{2}(?::\d{2})?)) (.*)$`)
+
+	// irssi: --- Log opened Mon Jan 02 15:04:05 2006, mIRC: Session Start: Mon Jan 02 15:04:05 2006
+	dateLine = regexp.MustCompile(`^(?:--- Log opened|--- Day changed|Session Start:) (.*)$`)
+)
+
+var dateLayouts = []string{
+	"Mon Jan _2 15:04:05 2006",
+	"Mon Jan _2 2006",
+	"Mon, Jan _2 2006",
+}
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"15:04:05",
+	"15:04",
+}
+
+type lineFormat struct {
+	kind LineKind
+	re   *regexp.Regexp
+}
+
+// lineFormats are the recognised line bodies, after the time stamp is
+// removed. Each expression has a nick group and optionally a text group.
+var lineFormats = []lineFormat{
+	// irssi, WeeChat and mIRC events
+	{LineJoin, regexp.MustCompile(`^(?:-!-|-->|\*) (?P<nick>\S+) [\[(][^\])]*[\])] has joined (?P<text>.*)$`)},
+	{LinePart, regexp.MustCompile(`^(?:-!-|<--|\*) (?P<nick>\S+) [\[(][^\])]*[\])] has left (?P<text>.*)$`)},
+	{LineQuit, regexp.MustCompile(`^(?:-!-|<--|\*) (?P<nick>\S+) [\[(][^\])]*[\])] (?:has quit|Quit) ?(?P<text>.*)$`)},
+
+	// ZNC and mIRC events
+	{LineJoin, regexp.MustCompile(`^(?:\*\*\*|\*) Joins: (?P<nick>\S+)(?: \([^)]*\))?$`)},
+	{LinePart, regexp.MustCompile(`^(?:\*\*\*|\*) Parts: (?P<nick>\S+)(?: \([^)]*\))?(?: \((?P<text>.*)\))?$`)},
+	{LineQuit, regexp.MustCompile(`^(?:\*\*\*|\*) Quits: (?P<nick>\S+)(?: \([^)]*\))?(?: \((?P<text>.*)\))?$`)},
+
+	// Messages, optionally with a mode prefix
+	{LineMessage, regexp.MustCompile(`^<[ ~&@%+]?(?P<nick>[^\s>]+)> ?(?P<text>.*)$`)},
+	{LineAction, regexp.MustCompile(`^\* (?P<nick>[^\s*]\S*) (?P<text>.*)$`)},
+	{LineNotice, regexp.MustCompile(`^-(?P<nick>[^\s!(-][^\s(-]*)(?:\([^)]*\))?- ?(?P<text>.*)$`)},
+}
+
+// Lines returns the structured log lines.
+func (p *IRC) Lines() []*Line {
+	return p.lines
+}
+
+// Filter returns a new parser with only the lines accepted by fn, the
+// returned parser can be used to render the excerpt.
+func (p *IRC) Filter(fn func(*Line) bool) (*IRC, error) {
+	var (
+		lines []*Line
+		raw   [][]byte
+	)
+	for _, l := range p.lines {
+		if fn(l) {
+			lines = append(lines, l)
+			raw = append(raw, l.Raw)
+		}
+	}
+
+	q := New(&p.options)
+	q.Proportional = p.Proportional
+	q.options.Encoding = nil // Raw lines are UTF-8
+	q.Date = p.Date
+	if err := q.Parse(bytes.NewReader(bytes.Join(raw, []byte{'\n'}))); err != nil {
+		return nil, err
+	}
+
+	// The excerpt may lack the lines that carry the date
+	for i, l := range q.lines {
+		l.Time = lines[i].Time
+	}
+	return q, nil
+}
+
+// Nick accepts lines from any of the nicks, nicks are not case sensitive.
+func Nick(nicks ...string) func(*Line) bool {
+	return func(l *Line) bool {
+		for _, nick := range nicks {
+			if strings.EqualFold(l.Nick, nick) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Between accepts lines with a time stamp in the range [from, to).
+func Between(from, to time.Time) func(*Line) bool {
+	return func(l *Line) bool {
+		return !l.Time.IsZero() && !l.Time.Before(from) && l.Time.Before(to)
+	}
+}
+
+// parse fills the structured fields from the unformatted line text,
+// date is updated if the line carries a date.
+func (l *Line) parse(text string, date *time.Time) {
+	var body, stamp string
+
+	if m := weechatLine.FindStringSubmatch(text); m != nil {
+		stamp = m[1]
+		switch prefix := strings.TrimSpace(m[2]); prefix {
+		case "-->", "<--", "--", "*":
+			body = prefix + " " + m[3]
+		default:
+			body = "<" + prefix + "> " + m[3]
+		}
+	} else if m := stampLine.FindStringSubmatch(text); m != nil {
+		stamp = m[1] + m[2]
+		body = strings.TrimLeft(m[3], " ")
+	} else if m := dateLine.FindStringSubmatch(text); m != nil {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, m[1]); err == nil {
+				*date = t
+				break
+			}
+		}
+		body = text
+	} else {
+		body = text
+	}
+
+	if stamp != "" {
+		l.Time = parseTime(stamp, *date)
+	}
+
+	l.Text = body
+	for _, format := range lineFormats {
+		m := format.re.FindStringSubmatch(body)
+		if m == nil {
+			continue
+		}
+		l.Kind = format.kind
+		l.Text = ""
+		for i, name := range format.re.SubexpNames() {
+			switch name {
+			case "nick":
+				l.Nick = m[i]
+			case "text":
+				l.Text = m[i]
+			}
+		}
+		return
+	}
+}
+
+// parseTime parses a time stamp, time stamps without a date are placed on
+// the supplied date.
+func parseTime(stamp string, date time.Time) time.Time {
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, stamp, date.Location())
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 && !date.IsZero() {
+			t = time.Date(date.Year(), date.Month(), date.Day(),
+				t.Hour(), t.Minute(), t.Second(), 0, date.Location())
+		}
+		return t
+	}
+	return time.Time{}
+}
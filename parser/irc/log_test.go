@@ -0,0 +1,113 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLines(t *testing.T) {
+	var tests = []struct {
+		Line string
+		Kind LineKind
+		Nick string
+		Text string
+		Time string
+	}{
+		// irssi
+		{"--- Log opened Mon Jan 02 15:04:05 2006", LineText, "", "--- Log opened Mon Jan 02 15:04:05 2006", ""},
+		{"15:04 < maze> hello \x0304world", LineMessage, "maze", "hello world", "2006-01-02 15:04:00"},
+		{"15:05  * maze waves", LineAction, "maze", "waves", "2006-01-02 15:05:00"},
+		{"15:06 -!- maze [~maze@example.org] has joined #piece", LineJoin, "maze", "#piece", "2006-01-02 15:06:00"},
+		{"15:07 -!- maze [~maze@example.org] has left #piece [bye]", LinePart, "maze", "#piece [bye]", "2006-01-02 15:07:00"},
+		{"15:08 -!- maze [~maze@example.org] has quit [Ping timeout]", LineQuit, "maze", "[Ping timeout]", "2006-01-02 15:08:00"},
+		{"--- Day changed Tue Jan 03 2006", LineText, "", "--- Day changed Tue Jan 03 2006", ""},
+		{"00:01 <@op> late", LineMessage, "op", "late", "2006-01-03 00:01:00"},
+
+		// WeeChat
+		{"2017-05-04 12:34:56\t@maze\thello", LineMessage, "maze", "hello", "2017-05-04 12:34:56"},
+		{"2017-05-04 12:34:57\t -- \tNotice(x): hi", LineText, "", "-- Notice(x): hi", "2017-05-04 12:34:57"},
+		{"2017-05-04 12:34:58\t *\tmaze waves", LineAction, "maze", "waves", "2017-05-04 12:34:58"},
+		{"2017-05-04 12:34:59\t-->\tmaze (~maze@example.org) has joined #piece", LineJoin, "maze", "#piece", "2017-05-04 12:34:59"},
+		{"2017-05-04 12:35:00\t<--\tmaze (~maze@example.org) has quit (Quit: bye)", LineQuit, "maze", "(Quit: bye)", "2017-05-04 12:35:00"},
+
+		// ZNC
+		{"[12:00:01] <maze> hello", LineMessage, "maze", "hello", "2006-01-03 12:00:01"},
+		{"[12:00:02] *** Joins: maze (~maze@example.org)", LineJoin, "maze", "", "2006-01-03 12:00:02"},
+		{"[12:00:03] *** Parts: maze (~maze@example.org) (later)", LinePart, "maze", "later", "2006-01-03 12:00:03"},
+
+		// mIRC
+		{"Session Start: Fri Mar 10 09:00:00 2017", LineText, "", "Session Start: Fri Mar 10 09:00:00 2017", ""},
+		{"[09:01] \x0303* maze (~maze@example.org) has joined #piece", LineJoin, "maze", "#piece", "2017-03-10 09:01:00"},
+		{"[09:02] -maze- private", LineNotice, "maze", "private", "2017-03-10 09:02:00"},
+		{"[09:03] * maze (~maze@example.org) Quit (Leaving)", LineQuit, "maze", "(Leaving)", "2017-03-10 09:03:00"},
+	}
+
+	var text []string
+	for _, test := range tests {
+		text = append(text, test.Line)
+	}
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(strings.Join(text, "\r\n") + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	lines := p.Lines()
+	if len(lines) != len(tests) {
+		t.Fatalf("expected %d lines, got %d", len(tests), len(lines))
+	}
+
+	for i, test := range tests {
+		l := lines[i]
+		if l.Kind != test.Kind || l.Nick != test.Nick || l.Text != test.Text {
+			t.Errorf("%q: expected kind %d, nick %q, text %q; got %d, %q, %q",
+				test.Line, test.Kind, test.Nick, test.Text, l.Kind, l.Nick, l.Text)
+		}
+		if test.Time == "" {
+			if !l.Time.IsZero() {
+				t.Errorf("%q: expected no time, got %s", test.Line, l.Time)
+			}
+		} else if s := l.Time.Format("2006-01-02 15:04:05"); s != test.Time {
+			t.Errorf("%q: expected time %s, got %s", test.Line, test.Time, s)
+		}
+		if l.Row != i || l.Rows != 1 {
+			t.Errorf("%q: expected row %d+1, got %d+%d", test.Line, i, l.Row, l.Rows)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	var text = strings.Join([]string{
+		"--- Log opened Mon Jan 02 15:04:05 2006",
+		"15:04 < maze> one",
+		"15:05 < other> two",
+		"15:06 < MAZE> \x0304three",
+		"15:07 < maze> four",
+	}, "\n")
+
+	p := New(nil)
+	if err := p.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := p.Filter(Nick("maze"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(q.Lines()); l != 3 {
+		t.Fatalf("expected 3 lines, got %d", l)
+	}
+
+	from := time.Date(2006, 1, 2, 15, 5, 0, 0, time.UTC)
+	to := from.Add(2 * time.Minute)
+	if q, err = q.Filter(Between(from, to)); err != nil {
+		t.Fatal(err)
+	}
+	lines := q.Lines()
+	if len(lines) != 1 || lines[0].Text != "three" || lines[0].Row != 0 {
+		t.Fatalf("expected line three, got %+v", lines)
+	}
+	if c := q.buffer.TileAt(14, 0); c.Char != 't' || c.Color != 4 {
+		t.Fatalf("expected red 't', got %s", c)
+	}
+}
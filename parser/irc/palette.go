@@ -0,0 +1,126 @@
+package irc
+
+import (
+	"image/color"
+
+	"git.maze.io/maze/go-piece/palette"
+)
+
+// Palette contains the 99 colors of the extended mIRC palette. Colors 0-15
+// are the classic mIRC colors, colors 16-98 were added by mIRC 7.
+var Palette = palette.Palette{
+	// Classic colors
+	color.RGBA{0xff, 0xff, 0xff, 0xff}, // White
+	color.RGBA{0x00, 0x00, 0x00, 0xff}, // Black
+	color.RGBA{0x00, 0x00, 0x7f, 0xff}, // Blue (navy)
+	color.RGBA{0x00, 0x93, 0x00, 0xff}, // Green
+	color.RGBA{0xff, 0x00, 0x00, 0xff}, // Red
+	color.RGBA{0x7f, 0x00, 0x00, 0xff}, // Brown (maroon)
+	color.RGBA{0x9c, 0x00, 0x9c, 0xff}, // Magenta (purple)
+	color.RGBA{0xfc, 0x7f, 0x00, 0xff}, // Orange
+	color.RGBA{0xff, 0xff, 0x00, 0xff}, // Yellow
+	color.RGBA{0x00, 0xfc, 0x00, 0xff}, // Light green
+	color.RGBA{0x00, 0x93, 0x93, 0xff}, // Cyan (teal)
+	color.RGBA{0x00, 0xff, 0xff, 0xff}, // Light cyan
+	color.RGBA{0x00, 0x00, 0xfc, 0xff}, // Light blue
+	color.RGBA{0xff, 0x00, 0xff, 0xff}, // Pink
+	color.RGBA{0x7f, 0x7f, 0x7f, 0xff}, // Grey
+	color.RGBA{0xd2, 0xd2, 0xd2, 0xff}, // Light grey
+
+	// Extended colors, 16-27
+	color.RGBA{0x47, 0x00, 0x00, 0xff},
+	color.RGBA{0x47, 0x21, 0x00, 0xff},
+	color.RGBA{0x47, 0x47, 0x00, 0xff},
+	color.RGBA{0x32, 0x47, 0x00, 0xff},
+	color.RGBA{0x00, 0x47, 0x00, 0xff},
+	color.RGBA{0x00, 0x47, 0x2c, 0xff},
+	color.RGBA{0x00, 0x47, 0x47, 0xff},
+	color.RGBA{0x00, 0x27, 0x47, 0xff},
+	color.RGBA{0x00, 0x00, 0x47, 0xff},
+	color.RGBA{0x2e, 0x00, 0x47, 0xff},
+	color.RGBA{0x47, 0x00, 0x47, 0xff},
+	color.RGBA{0x47, 0x00, 0x2a, 0xff},
+
+	// Extended colors, 28-39
+	color.RGBA{0x74, 0x00, 0x00, 0xff},
+	color.RGBA{0x74, 0x3a, 0x00, 0xff},
+	color.RGBA{0x74, 0x74, 0x00, 0xff},
+	color.RGBA{0x51, 0x74, 0x00, 0xff},
+	color.RGBA{0x00, 0x74, 0x00, 0xff},
+	color.RGBA{0x00, 0x74, 0x49, 0xff},
+	color.RGBA{0x00, 0x74, 0x74, 0xff},
+	color.RGBA{0x00, 0x40, 0x74, 0xff},
+	color.RGBA{0x00, 0x00, 0x74, 0xff},
+	color.RGBA{0x4b, 0x00, 0x74, 0xff},
+	color.RGBA{0x74, 0x00, 0x74, 0xff},
+	color.RGBA{0x74, 0x00, 0x45, 0xff},
+
+	// Extended colors, 40-51
+	color.RGBA{0xb5, 0x00, 0x00, 0xff},
+	color.RGBA{0xb5, 0x63, 0x00, 0xff},
+	color.RGBA{0xb5, 0xb5, 0x00, 0xff},
+	color.RGBA{0x7d, 0xb5, 0x00, 0xff},
+	color.RGBA{0x00, 0xb5, 0x00, 0xff},
+	color.RGBA{0x00, 0xb5, 0x71, 0xff},
+	color.RGBA{0x00, 0xb5, 0xb5, 0xff},
+	color.RGBA{0x00, 0x63, 0xb5, 0xff},
+	color.RGBA{0x00, 0x00, 0xb5, 0xff},
+	color.RGBA{0x75, 0x00, 0xb5, 0xff},
+	color.RGBA{0xb5, 0x00, 0xb5, 0xff},
+	color.RGBA{0xb5, 0x00, 0x6b, 0xff},
+
+	// Extended colors, 52-63
+	color.RGBA{0xff, 0x00, 0x00, 0xff},
+	color.RGBA{0xff, 0x8c, 0x00, 0xff},
+	color.RGBA{0xff, 0xff, 0x00, 0xff},
+	color.RGBA{0xb2, 0xff, 0x00, 0xff},
+	color.RGBA{0x00, 0xff, 0x00, 0xff},
+	color.RGBA{0x00, 0xff, 0xa0, 0xff},
+	color.RGBA{0x00, 0xff, 0xff, 0xff},
+	color.RGBA{0x00, 0x8c, 0xff, 0xff},
+	color.RGBA{0x00, 0x00, 0xff, 0xff},
+	color.RGBA{0xa5, 0x00, 0xff, 0xff},
+	color.RGBA{0xff, 0x00, 0xff, 0xff},
+	color.RGBA{0xff, 0x00, 0x98, 0xff},
+
+	// Extended colors, 64-75
+	color.RGBA{0xff, 0x59, 0x59, 0xff},
+	color.RGBA{0xff, 0xb4, 0x59, 0xff},
+	color.RGBA{0xff, 0xff, 0x71, 0xff},
+	color.RGBA{0xcf, 0xff, 0x60, 0xff},
+	color.RGBA{0x6f, 0xff, 0x6f, 0xff},
+	color.RGBA{0x65, 0xff, 0xc9, 0xff},
+	color.RGBA{0x6d, 0xff, 0xff, 0xff},
+	color.RGBA{0x59, 0xb4, 0xff, 0xff},
+	color.RGBA{0x59, 0x59, 0xff, 0xff},
+	color.RGBA{0xc4, 0x59, 0xff, 0xff},
+	color.RGBA{0xff, 0x66, 0xff, 0xff},
+	color.RGBA{0xff, 0x59, 0xbc, 0xff},
+
+	// Extended colors, 76-87
+	color.RGBA{0xff, 0x9c, 0x9c, 0xff},
+	color.RGBA{0xff, 0xd3, 0x9c, 0xff},
+	color.RGBA{0xff, 0xff, 0x9c, 0xff},
+	color.RGBA{0xe2, 0xff, 0x9c, 0xff},
+	color.RGBA{0x9c, 0xff, 0x9c, 0xff},
+	color.RGBA{0x9c, 0xff, 0xdb, 0xff},
+	color.RGBA{0x9c, 0xff, 0xff, 0xff},
+	color.RGBA{0x9c, 0xd3, 0xff, 0xff},
+	color.RGBA{0x9c, 0x9c, 0xff, 0xff},
+	color.RGBA{0xdc, 0x9c, 0xff, 0xff},
+	color.RGBA{0xff, 0x9c, 0xff, 0xff},
+	color.RGBA{0xff, 0x94, 0xd3, 0xff},
+
+	// Extended colors, 88-98 (gray scale ramp)
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0x13, 0x13, 0x13, 0xff},
+	color.RGBA{0x28, 0x28, 0x28, 0xff},
+	color.RGBA{0x36, 0x36, 0x36, 0xff},
+	color.RGBA{0x4d, 0x4d, 0x4d, 0xff},
+	color.RGBA{0x65, 0x65, 0x65, 0xff},
+	color.RGBA{0x81, 0x81, 0x81, 0xff},
+	color.RGBA{0x9f, 0x9f, 0x9f, 0xff},
+	color.RGBA{0xbc, 0xbc, 0xbc, 0xff},
+	color.RGBA{0xe2, 0xe2, 0xe2, 0xff},
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"git.maze.io/maze/go-piece/buffer"
+	"git.maze.io/maze/go-piece/font"
+	"git.maze.io/maze/go-piece/palette"
+	sauce "git.maze.io/maze/go-sauce"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// DefaultTabStop is the default tab stop width.
+const DefaultTabStop = 8
+
+// Options configure a parser. The zero value selects the defaults of the
+// format, or the values from the file and its SAUCE record.
+type Options struct {
+	// Width and Height of the canvas in characters.
+	Width, Height int
+
+	// Screen emulates a terminal with a fixed size screen, that scrolls in
+	// stead of growing. Only supported by terminal formats such as ANSi.
+	Screen bool
+
+	// Palette overrides the palette of the format.
+	Palette palette.Palette
+
+	// Font overrides the font of the format.
+	Font *font.Font
+
+	// NonBlink enables iCE colors, the blink attribute selects a bright
+	// background color in stead of blinking.
+	NonBlink bool
+
+	// LetterSpacing selects 8 or 9 pixel letter spacing, using the SAUCE
+	// letter spacing constants.
+	LetterSpacing uint8
+
+	// AspectRatio selects square or legacy non-square pixels, using the
+	// SAUCE aspect ratio constants.
+	AspectRatio uint8
+
+	// TabStop is the tab stop width.
+	TabStop int
+
+	// Encoding of the input text, the text is converted to code page 437 for
+	// display. If nil, the format's default encoding is assumed.
+	Encoding encoding.Encoding
+
+	// Strict makes Parse return the first warning as error.
+	Strict bool
+
+	// Limits on the canvas and palette size, for parsing untrusted input.
+	// Parse fails with ErrLimitExceeded if a limit is exceeded. Limits that
+	// are zero are taken from buffer.DefaultLimits, a negative limit turns
+	// the limit off.
+	Limits buffer.Limits
+}
+
+// Copy returns a copy of the options, or the zero options if o is nil. The
+// zero limits are filled in with the defaults.
+func (o *Options) Copy() Options {
+	var c Options
+	if o != nil {
+		c = *o
+	}
+	c.Limits = c.Limits.WithDefaults()
+	return c
+}
+
+// Flags returns the SAUCE flags f with the options applied.
+func (o *Options) Flags(f sauce.TFlags) sauce.TFlags {
+	if o == nil {
+		return f
+	}
+	if o.NonBlink {
+		f.NonBlink = true
+	}
+	if o.LetterSpacing != 0 {
+		f.LetterSpacing = o.LetterSpacing
+	}
+	if o.AspectRatio != 0 {
+		f.AspectRatio = o.AspectRatio
+	}
+	return f
+}
+
+// Tab returns the tab stop width.
+func (o *Options) Tab() int {
+	if o == nil || o.TabStop <= 0 {
+		return DefaultTabStop
+	}
+	return o.TabStop
+}
+
+// Reader returns a reader that converts the input to code page 437. If no
+// encoding is set, the input is returned as-is.
+func (o *Options) Reader(r io.Reader) io.Reader {
+	if o == nil || o.Encoding == nil {
+		return r
+	}
+	return transform.NewReader(r, transform.Chain(o.Encoding.NewDecoder(), toCP437{}))
+}
+
+// Writer returns a writer that converts the input to code page 437 and writes
+// it to w. Close flushes incomplete input. If no encoding is set, the input is
+// written as-is.
+func (o *Options) Writer(w io.Writer) io.WriteCloser {
+	if o == nil || o.Encoding == nil {
+		return nopCloser{w}
+	}
+	return transform.NewWriter(w, transform.Chain(o.Encoding.NewDecoder(), toCP437{}))
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// CP437 returns the code page 437 character for rune r. Runes that have no
+// code page 437 equivalent are replaced by a question mark.
+func CP437(r rune) byte {
+	if b, ok := charmap.CodePage437.EncodeRune(r); ok {
+		return b
+	}
+	return '?'
+}
+
+// toCP437 transforms UTF-8 to code page 437.
+type toCP437 struct{ transform.NopResetter }
+
+func (toCP437) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if nDst >= len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		r, size := utf8.DecodeRune(src[nSrc:])
+		if r == utf8.RuneError && !atEOF && !utf8.FullRune(src[nSrc:]) {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		dst[nDst] = CP437(r)
+		nDst++
+		nSrc += size
+	}
+	return
+}
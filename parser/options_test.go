@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	sauce "git.maze.io/maze/go-sauce"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestOptionsReader(t *testing.T) {
+	o := &Options{Encoding: unicode.UTF8}
+	b, err := ioutil.ReadAll(o.Reader(strings.NewReader("\x1b[0m█▓é☃")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []byte("\x1b[0m\xdb\xb2\x82?"); !bytes.Equal(b, e) {
+		t.Fatalf("expected %q, got %q", e, b)
+	}
+
+	var n *Options
+	if r := strings.NewReader("x"); n.Reader(r) != r {
+		t.Fatal("expected nil options to return the input reader")
+	}
+	if n.Tab() != DefaultTabStop {
+		t.Fatalf("expected default tab stop, got %d", n.Tab())
+	}
+}
+
+func TestOptionsFlags(t *testing.T) {
+	o := &Options{LetterSpacing: sauce.LetterSpacing9Pixel, AspectRatio: sauce.AspectRatioStretch}
+	f := o.Flags(sauce.TFlags{NonBlink: true, AspectRatio: sauce.AspectRatioSquare})
+	if !f.NonBlink || f.LetterSpacing != sauce.LetterSpacing9Pixel || f.AspectRatio != sauce.AspectRatioStretch {
+		t.Fatalf("expected the options to override the SAUCE flags, got %+v", f)
+	}
+
+	var n *Options
+	if f := n.Flags(sauce.TFlags{AspectRatio: sauce.AspectRatioStretch}); f.AspectRatio != sauce.AspectRatioStretch {
+		t.Fatalf("expected nil options to return the SAUCE flags, got %+v", f)
+	}
+}
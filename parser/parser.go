@@ -1,24 +1,34 @@
 package parser
 
 import (
+	"context"
 	"errors"
 	"image"
 	"io"
 
+	"git.maze.io/maze/go-piece/buffer"
 	"git.maze.io/maze/go-piece/font"
 	sauce "git.maze.io/maze/go-sauce"
 )
 
 var ErrNotSupported = errors.New(`piece: not supported`)
 
+// ErrLimitExceeded is wrapped by the errors of parsers that exceed the limits
+// in their options.
+var ErrLimitExceeded = buffer.ErrLimitExceeded
+
 // Parser implements a parser for artscene pieces
 type Parser interface {
+	Buffer() *buffer.Buffer
 	HTML(full bool) (string, error)
 	String() string
 	Font() *font.Font
 	Image(*font.Font) (image.Image, error)
+	Render(*font.Font, *buffer.RenderOptions) (image.Image, error)
 	Parse(io.Reader) error
+	ParseContext(context.Context, io.Reader) error
 	Width() int
 	Height() int
 	SAUCE() *sauce.SAUCE
+	Warnings() []Warning
 }
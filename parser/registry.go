@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sauce "git.maze.io/maze/go-sauce"
+)
+
+// ErrUnknownFormat is returned if no registered format matches the input.
+var ErrUnknownFormat = errors.New(`piece: unknown format`)
+
+// AnyFileType matches all SAUCE file types of a data type.
+const AnyFileType = -1
+
+// sampleSize is the number of bytes passed to the format probes and scorers
+const sampleSize = 64 << 10
+
+// Confidence of the evidence that a file is in a format, the evidence is
+// combined with the content score of the format. Only a matching SAUCE record
+// is conclusive, content scores are capped below it.
+const (
+	confidenceSAUCE     = 1.0
+	confidenceProbe     = 0.95
+	confidenceExtension = 0.3
+	confidenceScore     = 0.99
+)
+
+// SAUCEType is a SAUCE data type and file type pair.
+type SAUCEType struct {
+	DataType uint8
+	FileType int
+}
+
+// Match checks if the SAUCE record has this data type and file type.
+func (t SAUCEType) Match(s *sauce.SAUCE) bool {
+	return s.DataType == t.DataType && (t.FileType == AnyFileType || int(s.FileType) == t.FileType)
+}
+
+// Format describes a file format and how to parse it.
+type Format struct {
+	// Name is a human readable description of the format.
+	Name string
+
+	// Names select the format by name, the first name is the canonical name.
+	Names []string
+
+	// Extensions are the file name extensions, including the leading dot.
+	Extensions []string
+
+	// SAUCE types that identify the format.
+	SAUCE []SAUCEType
+
+	// Probe checks the head of the file for a magic byte sequence, may be nil
+	// for formats that can't be identified by a magic.
+	Probe func(head []byte) bool
+
+	// Score returns the confidence, in the range [0, 1], that a sample from
+	// the start of the file is in this format. The size is the file size
+	// without the SAUCE record. Scores are capped at 0.99, so they never tie
+	// with a matching SAUCE record. May be nil.
+	Score func(sample []byte, size int64) float64
+
+	// New returns a new parser, the options may be nil and the SAUCE record is
+	// nil if the file has none.
+	New func(o *Options, s *sauce.SAUCE) Parser
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []*Format
+)
+
+// Register makes a format available for detection and lookup. If Register is
+// called twice with the same name, it panics.
+func Register(f *Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if f == nil || f.New == nil {
+		panic("piece: Register format is nil")
+	}
+	for _, o := range formats {
+		for _, name := range f.Names {
+			if o.has(name) {
+				panic("piece: Register called twice for format " + name)
+			}
+		}
+	}
+	formats = append(formats, f)
+}
+
+// Formats returns the registered formats, sorted by their canonical name.
+func Formats() []*Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	var out = make([]*Format, len(formats))
+	copy(out, formats)
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Names[0] < out[j].Names[0]
+	})
+	return out
+}
+
+// Lookup returns the format by one of its names.
+func Lookup(name string) (*Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	for _, f := range formats {
+		if f.has(name) {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, name)
+}
+
+// Candidate is a format that matches a file with some confidence.
+type Candidate struct {
+	Format     *Format
+	Confidence float64
+}
+
+// Detect the format of a file, this returns the best ranked candidate. The
+// SAUCE record is returned if the file has one. The reader is rewound to the
+// start of the file.
+func Detect(name string, r io.ReadSeeker) (f *Format, s *sauce.SAUCE, err error) {
+	var c []Candidate
+	if c, s, err = Rank(name, r); err != nil {
+		return
+	}
+	if len(c) == 0 {
+		return nil, s, ErrUnknownFormat
+	}
+	return c[0].Format, s, nil
+}
+
+// Rank the registered formats by the confidence that the file is in that
+// format, formats that don't match at all are omitted. Evidence is taken from
+// the SAUCE record, the format probes and scorers and the file name extension.
+// The SAUCE record is returned if the file has one. The reader is rewound to
+// the start of the file.
+func Rank(name string, r io.ReadSeeker) (c []Candidate, s *sauce.SAUCE, err error) {
+	if s, err = sauce.Parse(r); err != nil {
+		// A missing or broken SAUCE record doesn't prevent detection
+		s, err = nil, nil
+	}
+
+	var size int64
+	if size, err = r.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	if s != nil {
+		if s.FileSize > 0 && int64(s.FileSize) < size {
+			size = int64(s.FileSize)
+		} else if size >= 128 {
+			size -= 128
+		}
+	}
+
+	var sample = make([]byte, sampleSize)
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	var n int
+	if n, err = io.ReadFull(r, sample); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	sample = sample[:n]
+	if int64(len(sample)) > size {
+		sample = sample[:size]
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, f := range formats {
+		if confidence := f.confidence(ext, sample, size, s); confidence > 0 {
+			c = append(c, Candidate{f, confidence})
+		}
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		return c[i].Confidence > c[j].Confidence
+	})
+	return
+}
+
+// confidence combines the independent pieces of evidence for a format.
+func (f *Format) confidence(ext string, sample []byte, size int64, s *sauce.SAUCE) float64 {
+	var p = 1.0 // Probability that the file is not in this format
+	if s != nil {
+		for _, t := range f.SAUCE {
+			if t.Match(s) {
+				p *= 1 - confidenceSAUCE
+				break
+			}
+		}
+	}
+	if f.Probe != nil && f.Probe(sample) {
+		p *= 1 - confidenceProbe
+	}
+	if f.Score != nil {
+		p *= 1 - math.Max(0, math.Min(confidenceScore, f.Score(sample, size)))
+	}
+	for _, e := range f.Extensions {
+		if e == ext {
+			p *= 1 - confidenceExtension
+			break
+		}
+	}
+	return 1 - p
+}
+
+func (f *Format) has(name string) bool {
+	for _, n := range f.Names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Magic returns a probe that checks if the file starts with magic.
+func Magic(magic []byte) func([]byte) bool {
+	return func(head []byte) bool {
+		return bytes.HasPrefix(head, magic)
+	}
+}
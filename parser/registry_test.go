@@ -0,0 +1,131 @@
+package parser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"git.maze.io/maze/go-piece/parser"
+	_ "git.maze.io/maze/go-piece/parser/ansi"
+	_ "git.maze.io/maze/go-piece/parser/binarytext"
+	_ "git.maze.io/maze/go-piece/parser/irc"
+	_ "git.maze.io/maze/go-piece/parser/xbin"
+)
+
+// testSAUCE returns a SAUCE record with the given data type and file type.
+func testSAUCE(dataType, fileType uint8) []byte {
+	var b = make([]byte, 128)
+	copy(b, "SAUCE00")
+	binary.LittleEndian.PutUint16(b[96:], 80)
+	b[94] = dataType
+	b[95] = fileType
+	return b
+}
+
+func TestDetect(t *testing.T) {
+	var tests = []struct {
+		Name   string
+		Data   []byte
+		Format string
+	}{
+		{"test.ans", []byte("\x1b[0mhello"), "ansi"},
+		{"TEST.DIZ", []byte("hello"), "ansi"},
+		{"test.xb", []byte("XBIN\x1a"), "xbin"},
+		{"FILE0001", []byte("XBIN\x1a"), "xbin"},
+		{"test.txt", []byte("XBIN\x1a"), "xbin"},
+		{"test.log", []byte("\x0304hello"), "irc"},
+		{"test.bin", []byte("\x41\x07"), "bin"},
+		{"FILE0002", append([]byte("\x41\x07\x1a"), testSAUCE(5, 40)...), "bin"},
+		{"test.txt", append([]byte("\x41\x07\x1a"), testSAUCE(6, 0)...), "xbin"},
+		{"test.bin", append([]byte("hello\x1a"), testSAUCE(1, 1)...), "ansi"},
+	}
+
+	for _, test := range tests {
+		f, _, err := parser.Detect(test.Name, bytes.NewReader(test.Data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		if f.Names[0] != test.Format {
+			t.Fatalf("%s: expected %s, got %s", test.Name, test.Format, f.Names[0])
+		}
+	}
+
+	if _, _, err := parser.Detect("FILE0003", bytes.NewReader([]byte("\x00\x01\x02"))); err != parser.ErrUnknownFormat {
+		t.Fatalf("expected %v, got %v", parser.ErrUnknownFormat, err)
+	}
+
+	// Plain text without a known extension is recognized as ASCII by the
+	// content heuristics
+	if f, _, err := parser.Detect("FILE0003", bytes.NewReader([]byte("hello"))); err != nil || f.Names[0] != "ansi" {
+		t.Fatalf("expected ansi, got %v", err)
+	}
+}
+
+func TestRankSAUCE(t *testing.T) {
+	// An IRC log that scores the maximum, with an ANSi SAUCE record
+	var log = bytes.Repeat([]byte("[12:00:01] <maze> \x02hello\x02\n"), 10)
+	c, _, err := parser.Rank("FILE0004", bytes.NewReader(append(append(log, 0x1a), testSAUCE(1, 1)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) < 2 || c[0].Format.Names[0] != "ansi" || c[1].Confidence >= c[0].Confidence {
+		t.Fatalf("expected the SAUCE match to rank first on its own, got %v", c)
+	}
+}
+
+func TestRank(t *testing.T) {
+	// Binary text, 80x2 tiles of shaded blocks with varying attributes
+	var bin []byte
+	for i := 0; i < 160; i++ {
+		bin = append(bin, 0xb0+byte(i%3), byte(i%16))
+	}
+
+	// IRC log without formatting codes
+	var log = []byte("[12:00:01] <maze> hello\n[12:00:02] <maze> world\n[12:00:03] * maze waves\n")
+
+	// ANSi without extension
+	var ans = bytes.Repeat([]byte("\x1b[1;34m\xdb\xdb\x1b[0m  "), 40)
+
+	var tests = []struct {
+		Name   string
+		Data   []byte
+		Format string
+	}{
+		{"FILE0001", bin, "bin"},
+		{"test.txt", bin, "bin"},
+		{"FILE0002", log, "irc"},
+		{"test.txt", log, "irc"},
+		{"FILE0003", ans, "ansi"},
+		{"test.log", ans, "ansi"},
+		{"FILE0004", []byte("just some text\r\n"), "ansi"},
+	}
+
+	for _, test := range tests {
+		c, _, err := parser.Rank(test.Name, bytes.NewReader(test.Data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		if len(c) == 0 {
+			t.Fatalf("%s: no candidates", test.Name)
+		}
+		if c[0].Format.Names[0] != test.Format {
+			t.Fatalf("%s: expected %s, got %s (%.2f)", test.Name, test.Format, c[0].Format.Names[0], c[0].Confidence)
+		}
+		for i := 1; i < len(c); i++ {
+			if c[i].Confidence > c[i-1].Confidence {
+				t.Fatalf("%s: candidates are not ranked", test.Name)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"ansi", "ASCII", "mirc", "binarytext", "xbin"} {
+		if _, err := parser.Lookup(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := parser.Lookup("sixel"); err == nil {
+		t.Fatal("expected lookup of unknown format to fail")
+	}
+}
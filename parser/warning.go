@@ -0,0 +1,82 @@
+package parser
+
+import "fmt"
+
+// WarningKind classifies parse warnings.
+type WarningKind int
+
+// Warning kinds
+const (
+	WarningUnsupported WarningKind = iota // Unsupported sequence or mode
+	WarningMalformed                      // Malformed sequence or parameters
+	WarningSAUCE                          // Invalid SAUCE record
+	WarningTruncated                      // Input is shorter than expected
+)
+
+var warningKinds = map[WarningKind]string{
+	WarningUnsupported: "unsupported",
+	WarningMalformed:   "malformed",
+	WarningSAUCE:       "invalid SAUCE",
+	WarningTruncated:   "truncated",
+}
+
+func (k WarningKind) String() string {
+	if s, ok := warningKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("WarningKind(%d)", int(k))
+}
+
+// Warning is a problem in the input that didn't stop the parser. In strict
+// mode, the warning is returned as error by Parse.
+type Warning struct {
+	Kind WarningKind
+
+	// Offset of the problem in the input, in bytes
+	Offset int64
+
+	// Sequence is the offending input, may be empty
+	Sequence string
+
+	// Message describes the problem
+	Message string
+}
+
+func (w *Warning) Error() string {
+	if w.Sequence == "" {
+		return fmt.Sprintf("%s at offset %d: %s", w.Kind, w.Offset, w.Message)
+	}
+	return fmt.Sprintf("%s at offset %d: %s %q", w.Kind, w.Offset, w.Message, w.Sequence)
+}
+
+// WarningLog collects the warnings of a parser.
+type WarningLog struct {
+	// Strict returns warnings as errors.
+	Strict bool
+
+	warnings []Warning
+}
+
+// Warn records a warning. In strict mode, the warning is returned as error.
+func (l *WarningLog) Warn(kind WarningKind, offset int64, sequence, format string, v ...interface{}) error {
+	l.warnings = append(l.warnings, Warning{
+		Kind:     kind,
+		Offset:   offset,
+		Sequence: sequence,
+		Message:  fmt.Sprintf(format, v...),
+	})
+	if l.Strict {
+		return &l.warnings[len(l.warnings)-1]
+	}
+	return nil
+}
+
+// Warnings returns the warnings of the last Parse.
+func (l *WarningLog) Warnings() []Warning {
+	return l.warnings
+}
+
+// ResetWarnings clears the recorded warnings.
+func (l *WarningLog) ResetWarnings() {
+	l.warnings = nil
+}
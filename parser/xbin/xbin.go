@@ -3,6 +3,7 @@ package xbin
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -24,6 +25,7 @@ var (
 	XBINID = []byte("XBIN")
 
 	errCompress  = errors.New("Invalid compression byte in XBIN")
+	errFontSize  = errors.New("Invalid font size in XBIN")
 	errNotXBIN   = errors.New("Not an XBIN")
 	errShortRead = errors.New("Short read")
 
@@ -63,12 +65,14 @@ var compression = map[uint8]string{
 
 // XBIN implements the eXtended Binary text format
 type XBIN struct {
-	Palette palette.Palette
-	buffer  *buffer.Buffer
-	data    []byte
-	font    *font.Font
-	header  Header
-	sauce   *sauce.SAUCE
+	Palette  palette.Palette
+	buffer   *buffer.Buffer
+	data     []byte
+	font     *font.Font
+	header   Header
+	options  parser.Options
+	sauce    *sauce.SAUCE
+	warnings parser.WarningLog
 }
 
 // Header implements the eXtended Binary header format
@@ -80,17 +84,45 @@ type Header struct {
 	Flags         uint8
 }
 
-// New initializes a new eXtended Binary parser
-func New() *XBIN {
+// New initializes a new eXtended Binary parser, the options may be nil. The
+// palette and font in the options take precedence over the ones in the XBIN.
+func New(o *parser.Options) *XBIN {
 	p := &XBIN{
 		Palette: binarytext.Palette,
 		buffer:  buffer.New(80, 1),
+		options: o.Copy(),
+	}
+	p.warnings.Strict = p.options.Strict
+	if p.options.Palette != nil {
+		p.Palette = p.options.Palette.Copy()
 	}
 	return p
 }
 
+func init() {
+	parser.Register(&parser.Format{
+		Name:       "eXtended Binary text",
+		Names:      []string{"xbin"},
+		Extensions: []string{".xb"},
+		SAUCE: []parser.SAUCEType{
+			{DataType: sauce.DataTypeXBIN, FileType: parser.AnyFileType},
+		},
+		Probe: parser.Magic(XBINID),
+		New: func(o *parser.Options, _ *sauce.SAUCE) parser.Parser {
+			return New(o)
+		},
+	})
+}
+
 // Parse the eXtended Binary buffer
-func (p *XBIN) Parse(r io.Reader) (err error) {
+func (p *XBIN) Parse(r io.Reader) error {
+	return p.ParseContext(context.Background(), r)
+}
+
+// ParseContext parses the eXtended Binary buffer, until the context is done.
+func (p *XBIN) ParseContext(ctx context.Context, in io.Reader) (err error) {
+	p.warnings.ResetWarnings()
+	r := &countReader{r: parser.ContextReader(ctx, in)}
 	r.Read(p.header.ID[:])
 	if !bytes.Equal(p.header.ID[:], XBINID) {
 		return errNotXBIN
@@ -104,10 +136,16 @@ func (p *XBIN) Parse(r io.Reader) (err error) {
 	if err != nil {
 		return fmt.Errorf(errStrHeader, err)
 	}
+	if p.header.Flags&FlagFont > 0 && (p.header.Fontsize < 1 || p.header.Fontsize > 32) {
+		// Fonts are 1 to 32 pixels high
+		return fmt.Errorf(errStrHeader, errFontSize)
+	}
+	if err = p.options.Limits.Check(int(p.header.Width), int(p.header.Height)); err != nil {
+		return
+	}
 
 	// Parse palette, if set
 	if p.header.Flags&FlagPalette > 0 {
-		//log.Println("xbin: custom palette")
 		pal := palette.Palette{}
 
 		for i := 0; i < 16; i++ {
@@ -128,7 +166,9 @@ func (p *XBIN) Parse(r io.Reader) (err error) {
 				0xff,
 			})
 		}
-		p.Palette = pal
+		if p.options.Palette == nil {
+			p.Palette = pal
+		}
 	}
 
 	// Parse font, if set
@@ -158,7 +198,13 @@ func (p *XBIN) Parse(r io.Reader) (err error) {
 		}
 	} else {
 		p.data = make([]byte, l)
-		if _, err = r.Read(p.data); err != nil {
+		var n int
+		if n, err = io.ReadFull(r, p.data); err == io.ErrUnexpectedEOF {
+			// Missing cells are left blank
+			if err = p.warnings.Warn(parser.WarningTruncated, r.n, "", "image data is %d of %d bytes", n, l); err != nil {
+				return
+			}
+		} else if err != nil {
 			return fmt.Errorf(errStrImage, err)
 		}
 	}
@@ -166,20 +212,37 @@ func (p *XBIN) Parse(r io.Reader) (err error) {
 	// Initialize buffer
 	w := int(p.header.Width)
 	h := int(p.header.Height)
-	//log.Printf("xbin: creating a %d x %d piece\n", w, h)
 	p.buffer = buffer.New(w, h)
+	p.buffer.Limits = p.options.Limits
 
 	// Parse remaining data, scanning for a SAUCE header
-	var d []byte
+	var (
+		d     []byte
+		flags sauce.TFlags
+	)
+	offset := r.n
 	if d, err = ioutil.ReadAll(r); err == nil {
 		var s *sauce.SAUCE
-		if s, err = sauce.ParseBytes(d); err == nil && s.DataType == sauce.DataTypeXBIN {
-			p.buffer.Flags = s.TFlags
+		switch s, err = sauce.ParseBytes(d); {
+		case err == nil && s.DataType == sauce.DataTypeXBIN:
+			p.sauce = s
+			flags = s.TFlags
+		case err == nil:
+			err = p.warnings.Warn(parser.WarningSAUCE, offset, "", "unexpected data type %d", s.DataType)
+		case err == sauce.ErrNoRecord:
+			err = nil
+		default:
+			err = p.warnings.Warn(parser.WarningSAUCE, offset, "", "%v", err)
+		}
+		if err != nil {
+			return
 		}
-		err = nil // Don't bleed unimportant SAUCE warnings
 	}
+	if p.header.Flags&FlagNonBlink > 0 {
+		flags.NonBlink = true
+	}
+	p.buffer.Flags = p.options.Flags(flags)
 
-	//log.Printf("xbin: loading %d bytes of memory\n", len(p.data))
 	p.buffer.FromMemory(p.data)
 	return
 }
@@ -204,8 +267,16 @@ func (p *XBIN) String() string {
 	return string(b)
 }
 
+// Buffer returns the internal buffer.
+func (p *XBIN) Buffer() *buffer.Buffer {
+	return p.buffer
+}
+
 // Font returns the font for this XBIN.
 func (p *XBIN) Font() *font.Font {
+	if p.options.Font != nil {
+		return p.options.Font
+	}
 	return p.font
 }
 
@@ -226,6 +297,11 @@ func (p *XBIN) Image(f *font.Font) (m image.Image, err error) {
 	return p.buffer.Image(p.Palette, f)
 }
 
+// Render returns the internal buffer as an image, with render options o.
+func (p *XBIN) Render(f *font.Font, o *buffer.RenderOptions) (image.Image, error) {
+	return p.buffer.Render(p.Palette, f, o)
+}
+
 // SetFlags imports SAUCE flags
 func (p *XBIN) SetFlags(f sauce.TFlags) {
 	p.buffer.Flags = f
@@ -235,6 +311,23 @@ func (p *XBIN) SAUCE() *sauce.SAUCE {
 	return p.sauce
 }
 
+// Warnings returns the problems found during the last Parse.
+func (p *XBIN) Warnings() []parser.Warning {
+	return p.warnings.Warnings()
+}
+
+// countReader counts the number of bytes read
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	return
+}
+
 func decompress(src io.Reader, size int) (dst []byte, err error) {
 	dst = make([]byte, 0)
 
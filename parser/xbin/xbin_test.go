@@ -0,0 +1,31 @@
+package xbin
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFontSize(t *testing.T) {
+	for _, size := range []byte{0, 33} {
+		// A 1x1 XBIN with a font of the given height
+		input := append([]byte("XBIN\x1a\x01\x00\x01\x00"), size, FlagFont)
+		input = append(input, make([]byte, int(size)*256+2)...)
+
+		err := New(nil).Parse(bytes.NewReader(input))
+		if err == nil || !strings.Contains(err.Error(), errFontSize.Error()) {
+			t.Errorf("font size %d: expected font size error, got %v", size, err)
+		}
+	}
+
+	input := append([]byte("XBIN\x1a\x01\x00\x01\x00\x08"), FlagFont)
+	input = append(input, make([]byte, 8*256)...)
+	input = append(input, 'x', 0x07)
+	p := New(nil)
+	if err := p.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if f := p.Font(); f == nil || f.Size.Y != 8 {
+		t.Errorf("expected an 8 pixel font, got %v", f)
+	}
+}